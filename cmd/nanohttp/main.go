@@ -1,18 +1,29 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"flag"
 	"fmt"
-	"net"
-	"net/http"
+	"math"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/mguptahub/nanoHttp/internal/ca"
+	"github.com/mguptahub/nanoHttp/internal/config"
+	"github.com/mguptahub/nanoHttp/internal/daemon"
+	"github.com/mguptahub/nanoHttp/internal/logfile"
 	"github.com/mguptahub/nanoHttp/internal/server"
+	"github.com/mguptahub/nanoHttp/internal/supervisor"
+	"golang.org/x/term"
 )
 
 const (
@@ -29,6 +40,7 @@ func main() {
 	}
 
 	// Initialize server manager
+	server.Version = appVersion
 	manager := server.NewManager(configDir)
 
 	// Parse command line arguments
@@ -42,13 +54,27 @@ func main() {
 	case "add":
 		handleAdd(manager)
 	case "start":
-		handleStart(manager)
+		handleStart(manager, configDir)
 	case "stop":
-		handleStop(manager)
+		handleStop(manager, configDir)
 	case "delete":
-		handleDelete(manager)
+		handleDelete(manager, configDir)
+	case "daemon":
+		handleDaemon(configDir)
+	case "ca":
+		handleCA(configDir)
+	case "user":
+		handleUser(manager)
+	case "token":
+		handleToken(manager)
+	case "sign":
+		handleSign(manager)
 	case "list":
-		handleList(manager)
+		handleList(manager, configDir)
+	case "logs":
+		handleLogs(manager)
+	case "status":
+		handleStatus(configDir)
 	case "update":
 		handleUpdate()
 	case "version":
@@ -68,6 +94,13 @@ func printHelp() {
 	fmt.Println("  stop    Stop a server instance")
 	fmt.Println("  delete  Delete a server instance")
 	fmt.Println("  list    List all server instances")
+	fmt.Println("  logs    Show the logs of a server instance")
+	fmt.Println("  status  Check the health of server instances")
+	fmt.Println("  daemon  Run all instances inside a single supervisor process")
+	fmt.Println("  ca      Manage the local certificate authority used by -tls-auto")
+	fmt.Println("  user    Manage the Basic auth users of a server instance")
+	fmt.Println("  token   Manage the access tokens of a server instance")
+	fmt.Println("  sign    Create an expiring signed URL for a path of a server instance")
 	fmt.Println("  update  Check for and install updates")
 	fmt.Println("  version Show version information")
 	fmt.Println("\nUse --help with any command for detailed usage information")
@@ -78,17 +111,127 @@ func handleAdd(manager *server.Manager) {
 	addCmd.Usage = func() {
 		fmt.Println("Usage: nanoHttp add [options]")
 		fmt.Println("\nOptions:")
+		fmt.Printf("  -access-log                 Log requests in the given format: common, combined or json\n")
+		fmt.Printf("  -access-log-file            Access log file (default ~/.nanoHttp/logs/<name>/access.log)\n")
+		fmt.Printf("  -allow                      Only serve clients from these comma-separated IPs or CIDR blocks (repeatable)\n")
+		fmt.Printf("  -auth                       Require HTTP Basic auth from users added with \"nanoHttp user add\"\n")
+		fmt.Printf("  -auth-file                  htpasswd file with bcrypt, SHA or APR1 hashes to use, enables -auth\n")
+		fmt.Printf("  -auth-path                  Only require auth below these comma-separated path prefixes (repeatable)\n")
+		fmt.Printf("  -auth-realm                 Realm shown in the login prompt (default the instance name)\n")
+		fmt.Printf("  -compress                   Compress responses on the fly (gzip and brotli)\n")
+		fmt.Printf("  -compress-encodings         Comma-separated encodings in order of preference (default br,gzip)\n")
+		fmt.Printf("  -compress-min-size          Smallest response size in bytes to compress (default %d)\n", server.DefaultCompressMinSize)
+		fmt.Printf("  -compress-types             Comma-separated MIME types to compress, \"text/*\" style wildcards allowed\n")
 		fmt.Printf("  -d | -allow-dir-listing     Allow directory listing\n")
+		fmt.Printf("  -deny                       Refuse clients from these comma-separated IPs or CIDR blocks (repeatable)\n")
+		fmt.Printf("  -default-host               Serve requests for hostnames no other instance on the port claims\n")
+		fmt.Printf("  -error-page                 Custom error page as CODE=PATH relative to the web folder (repeatable)\n")
+		fmt.Printf("  -health                     Serve liveness and readiness checks at /healthz and /readyz\n")
+		fmt.Printf("  -health-path                Path of the liveness endpoint (default %s)\n", server.DefaultLivenessPath)
+		fmt.Printf("  -host                       Comma-separated hostnames this instance answers to, \"*.example.com\" style\n")
+		fmt.Printf("                              wildcards allowed (repeatable); lets instances share a port\n")
+		fmt.Printf("  -log-compress               Gzip rotated log files\n")
+		fmt.Printf("  -log-max-age                Rotate logs older than this duration, e.g. 24h (default never)\n")
+		fmt.Printf("  -log-max-files              Rotated log files to keep (default %d)\n", logfile.DefaultMaxFiles)
+		fmt.Printf("  -log-max-size               Rotate logs larger than this many megabytes (default %d)\n", logfile.DefaultMaxSizeMB)
+		fmt.Printf("  -max-requests               Requests served at once before answering 429 Too Many Requests\n")
+		fmt.Printf("  -metrics                    Serve Prometheus metrics at /metrics\n")
+		fmt.Printf("  -metrics-path               Path of the metrics endpoint (default %s)\n", server.DefaultMetricsPath)
+		fmt.Printf("  -metrics-port               Serve metrics on this port instead of the instance's port\n")
+		fmt.Printf("  -mount                      Serve another folder at a path prefix as PREFIX=PATH[;option...] (repeatable)\n")
+		fmt.Printf("                              options: listing, spa, spa-fallback=FILE\n")
 		fmt.Printf("  -n | -name                  Instance name (required)\n")
 		fmt.Printf("  -p | -port                  Port number (default 8080)\n")
+		fmt.Printf("  -max-restarts               Maximum restarts before giving up (default 5, 0 for unlimited)\n")
+		fmt.Printf("  -signed-url-path            Only require a signed URL below these comma-separated path prefixes (repeatable)\n")
+		fmt.Printf("  -signed-urls                Accept expiring URLs created with \"nanoHttp sign\" for protected paths\n")
+		fmt.Printf("  -spa                        Serve index.html for paths that don't match a file (client-side routing)\n")
+		fmt.Printf("  -spa-fallback               File served by -spa instead of index.html, relative to the web folder\n")
+		fmt.Printf("  -proxy                      Forward a path prefix to upstreams as PREFIX=URL[,URL...][;option...] (repeatable)\n")
+		fmt.Printf("                              options: strip, preserve-host, header=Name:Value, response-header=Name:Value,\n")
+		fmt.Printf("                              strategy=round-robin|least-conn|ip-hash, retries=N, health-check=PATH,\n")
+		fmt.Printf("                              health-interval=DURATION, health-timeout=DURATION\n")
+		fmt.Printf("  -rate-burst                 Requests a client may send at once under -rate-limit (default the rate)\n")
+		fmt.Printf("  -rate-limit                 Requests per second each client may send on average, 429 beyond that\n")
+		fmt.Printf("  -rate-limit-by              Tell clients apart by ip, or by the user or token they sign in with (default ip)\n")
+		fmt.Printf("  -ready-path                 Path of the readiness endpoint (default %s)\n", server.DefaultReadinessPath)
+		fmt.Printf("  -rule                       Rewrite or redirect matching paths as \"PATTERN TARGET [STATUS] [option...]\"\n")
+		fmt.Printf("                              (repeatable, first match applies); PATTERN is a regular expression,\n")
+		fmt.Printf("                              TARGET may use $1 captures, STATUS 301-308 redirects (default rewrite);\n")
+		fmt.Printf("                              options: method=GET,POST, header=Name:REGEX\n")
+		fmt.Printf("  -restart                    Restart policy: never, on-failure or always (default never)\n")
+		fmt.Printf("  -restart-backoff            Delay before the first restart, doubled on each retry (default 1s)\n")
+		fmt.Printf("  -restart-max-backoff        Upper bound for the restart delay (default 1m)\n")
+		fmt.Printf("  -token-auth                 Require a token created with \"nanoHttp token create\", sent as a bearer\n")
+		fmt.Printf("                              token, %s header or %s query parameter\n", server.APIKeyHeader, server.TokenQueryParam)
+		fmt.Printf("  -token-auth-path            Only require a token below these comma-separated path prefixes (repeatable)\n")
+		fmt.Printf("  -trusted-proxy              Take the client address of -allow, -deny, -rate-limit and ip-hash from X-Forwarded-For when\n")
+		fmt.Printf("                              the request comes from these comma-separated IPs or CIDR blocks (repeatable)\n")
+		fmt.Printf("  -tls-auto                   Enable HTTPS with a certificate issued by the local nanoHttp CA\n")
+		fmt.Printf("  -tls-cert                   TLS certificate file, enables HTTPS (requires -tls-key)\n")
+		fmt.Printf("  -tls-ciphers                Comma-separated TLS 1.0-1.2 cipher suite names (default Go's secure set)\n")
+		fmt.Printf("  -tls-hosts                  Comma-separated extra hostnames for -tls-auto certificates\n")
+		fmt.Printf("  -tls-key                    TLS private key file\n")
+		fmt.Printf("  -tls-min-version            Minimum TLS version: 1.0, 1.1, 1.2 or 1.3 (default 1.2)\n")
+		fmt.Printf("  -tls-redirect-port          Also listen for plain HTTP on this port and redirect to HTTPS\n")
 		fmt.Printf("  -w | -web-folder            Web root folder (required, relative paths will be converted to absolute)\n")
 	}
 
 	var (
-		name            string
-		port            int
-		webFolder       string
-		allowDirListing bool
+		name              string
+		port              int
+		webFolder         string
+		allowDirListing   bool
+		restartPolicy     string
+		maxRestarts       int
+		restartBackoff    time.Duration
+		restartMaxBackoff time.Duration
+		tlsCert           string
+		tlsKey            string
+		tlsMinVersion     string
+		tlsCiphers        string
+		tlsRedirectPort   int
+		tlsAuto           bool
+		tlsHosts          string
+		spa               bool
+		spaFallback       string
+		errorPages        listFlag
+		compress          bool
+		compressEncodings string
+		compressMinSize   int
+		compressTypes     string
+		accessLogFormat   string
+		accessLogFile     string
+		logMaxSize        int
+		logMaxAge         time.Duration
+		logMaxFiles       int
+		logCompress       bool
+		metrics           bool
+		metricsPath       string
+		metricsPort       int
+		health            bool
+		healthPath        string
+		readyPath         string
+		proxies           listFlag
+		hosts             listFlag
+		defaultHost       bool
+		mounts            listFlag
+		rules             listFlag
+		auth              bool
+		authFile          string
+		authPaths         listFlag
+		authRealm         string
+		tokenAuth         bool
+		tokenAuthPaths    listFlag
+		signedURLs        bool
+		signedURLPaths    listFlag
+		allowIPs          listFlag
+		denyIPs           listFlag
+		trustedProxies    listFlag
+		rateLimit         float64
+		rateBurst         int
+		rateLimitBy       string
+		maxRequests       int
 	)
 
 	// Define flags with aliases
@@ -98,8 +241,58 @@ func handleAdd(manager *server.Manager) {
 	addCmd.IntVar(&port, "p", 8080, "")
 	addCmd.StringVar(&webFolder, "web-folder", "", "")
 	addCmd.StringVar(&webFolder, "w", "", "")
+	addCmd.Var(&hosts, "host", "")
+	addCmd.BoolVar(&defaultHost, "default-host", false, "")
 	addCmd.BoolVar(&allowDirListing, "allow-dir-listing", false, "")
 	addCmd.BoolVar(&allowDirListing, "d", false, "")
+	addCmd.Var(&mounts, "mount", "")
+	addCmd.Var(&errorPages, "error-page", "")
+	addCmd.BoolVar(&compress, "compress", false, "")
+	addCmd.StringVar(&compressEncodings, "compress-encodings", "", "")
+	addCmd.IntVar(&compressMinSize, "compress-min-size", 0, "")
+	addCmd.StringVar(&compressTypes, "compress-types", "", "")
+	addCmd.StringVar(&accessLogFormat, "access-log", "", "")
+	addCmd.StringVar(&accessLogFile, "access-log-file", "", "")
+	addCmd.IntVar(&logMaxSize, "log-max-size", 0, "")
+	addCmd.DurationVar(&logMaxAge, "log-max-age", 0, "")
+	addCmd.IntVar(&logMaxFiles, "log-max-files", 0, "")
+	addCmd.BoolVar(&logCompress, "log-compress", false, "")
+	addCmd.BoolVar(&metrics, "metrics", false, "")
+	addCmd.StringVar(&metricsPath, "metrics-path", "", "")
+	addCmd.IntVar(&metricsPort, "metrics-port", 0, "")
+	addCmd.BoolVar(&health, "health", false, "")
+	addCmd.StringVar(&healthPath, "health-path", "", "")
+	addCmd.StringVar(&readyPath, "ready-path", "", "")
+	addCmd.Var(&proxies, "proxy", "")
+	addCmd.Var(&rules, "rule", "")
+	addCmd.Var(&allowIPs, "allow", "")
+	addCmd.Var(&denyIPs, "deny", "")
+	addCmd.Var(&trustedProxies, "trusted-proxy", "")
+	addCmd.Float64Var(&rateLimit, "rate-limit", 0, "")
+	addCmd.IntVar(&rateBurst, "rate-burst", 0, "")
+	addCmd.StringVar(&rateLimitBy, "rate-limit-by", "", "")
+	addCmd.IntVar(&maxRequests, "max-requests", 0, "")
+	addCmd.BoolVar(&auth, "auth", false, "")
+	addCmd.StringVar(&authFile, "auth-file", "", "")
+	addCmd.Var(&authPaths, "auth-path", "")
+	addCmd.StringVar(&authRealm, "auth-realm", "", "")
+	addCmd.BoolVar(&tokenAuth, "token-auth", false, "")
+	addCmd.Var(&tokenAuthPaths, "token-auth-path", "")
+	addCmd.BoolVar(&signedURLs, "signed-urls", false, "")
+	addCmd.Var(&signedURLPaths, "signed-url-path", "")
+	addCmd.BoolVar(&spa, "spa", false, "")
+	addCmd.StringVar(&spaFallback, "spa-fallback", "", "")
+	addCmd.StringVar(&restartPolicy, "restart", config.RestartNever, "")
+	addCmd.IntVar(&maxRestarts, "max-restarts", 5, "")
+	addCmd.DurationVar(&restartBackoff, "restart-backoff", time.Second, "")
+	addCmd.DurationVar(&restartMaxBackoff, "restart-max-backoff", time.Minute, "")
+	addCmd.StringVar(&tlsCert, "tls-cert", "", "")
+	addCmd.StringVar(&tlsKey, "tls-key", "", "")
+	addCmd.StringVar(&tlsMinVersion, "tls-min-version", "", "")
+	addCmd.StringVar(&tlsCiphers, "tls-ciphers", "", "")
+	addCmd.IntVar(&tlsRedirectPort, "tls-redirect-port", 0, "")
+	addCmd.BoolVar(&tlsAuto, "tls-auto", false, "")
+	addCmd.StringVar(&tlsHosts, "tls-hosts", "", "")
 
 	// Handle --help explicitly
 	if len(os.Args) > 2 && (os.Args[2] == "--help" || os.Args[2] == "-h") {
@@ -120,11 +313,188 @@ func handleAdd(manager *server.Manager) {
 		os.Exit(1)
 	}
 
+	if err := supervisor.ValidateMode(restartPolicy); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	instance := &server.Instance{
 		Name:            name,
 		Port:            port,
 		WebFolder:       webFolder,
 		AllowDirListing: allowDirListing,
+		SPA:             spa || spaFallback != "",
+		SPAFallback:     spaFallback,
+		DefaultHost:     defaultHost,
+	}
+
+	for _, value := range hosts {
+		instance.Hosts = append(instance.Hosts, splitList(value)...)
+	}
+
+	for _, value := range mounts {
+		mount, err := server.ParseMount(value)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		instance.Mounts = append(instance.Mounts, mount)
+	}
+
+	for _, value := range errorPages {
+		code, page, err := server.ParseErrorPage(value)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if instance.ErrorPages == nil {
+			instance.ErrorPages = make(map[int]string)
+		}
+		instance.ErrorPages[code] = page
+	}
+
+	if compress || compressEncodings != "" || compressMinSize != 0 || compressTypes != "" {
+		instance.Compression = &config.CompressionConfig{
+			Encodings: splitList(compressEncodings),
+			MinSize:   compressMinSize,
+			MimeTypes: splitList(compressTypes),
+		}
+	}
+
+	if accessLogFormat != "" || accessLogFile != "" {
+		instance.AccessLog = &config.AccessLogConfig{
+			Format: accessLogFormat,
+			File:   accessLogFile,
+		}
+	}
+
+	if logMaxSize != 0 || logMaxAge != 0 || logMaxFiles != 0 || logCompress {
+		if logMaxSize < 0 || logMaxAge < 0 || logMaxFiles < 0 {
+			fmt.Println("Error: log rotation settings must not be negative")
+			os.Exit(1)
+		}
+		instance.Logs = &config.LogConfig{
+			MaxSizeMB: logMaxSize,
+			MaxAge:    config.Duration(logMaxAge),
+			MaxFiles:  logMaxFiles,
+			Compress:  logCompress,
+		}
+	}
+
+	if metrics || metricsPath != "" || metricsPort != 0 {
+		instance.Metrics = &config.MetricsConfig{
+			Path: metricsPath,
+			Port: metricsPort,
+		}
+	}
+
+	for _, value := range proxies {
+		route, err := server.ParseProxyRoute(value)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		instance.Proxies = append(instance.Proxies, route)
+	}
+
+	for _, value := range rules {
+		rule, err := server.ParseRule(value)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		instance.Rules = append(instance.Rules, rule)
+	}
+
+	if len(allowIPs) > 0 || len(denyIPs) > 0 || len(trustedProxies) > 0 {
+		instance.IPFilter = &config.IPFilterConfig{}
+		for _, value := range allowIPs {
+			instance.IPFilter.Allow = append(instance.IPFilter.Allow, splitList(value)...)
+		}
+		for _, value := range denyIPs {
+			instance.IPFilter.Deny = append(instance.IPFilter.Deny, splitList(value)...)
+		}
+		for _, value := range trustedProxies {
+			instance.IPFilter.TrustedProxies = append(instance.IPFilter.TrustedProxies, splitList(value)...)
+		}
+	}
+
+	if rateLimit != 0 || rateBurst != 0 || rateLimitBy != "" || maxRequests != 0 {
+		instance.RateLimit = &config.RateLimitConfig{
+			Rate:        rateLimit,
+			Burst:       rateBurst,
+			By:          rateLimitBy,
+			MaxRequests: maxRequests,
+		}
+	}
+
+	if auth || authFile != "" || len(authPaths) > 0 || authRealm != "" {
+		instance.BasicAuth = &config.BasicAuthConfig{
+			File:  authFile,
+			Realm: authRealm,
+		}
+		for _, value := range authPaths {
+			instance.BasicAuth.Paths = append(instance.BasicAuth.Paths, splitList(value)...)
+		}
+	}
+
+	if tokenAuth || len(tokenAuthPaths) > 0 {
+		instance.TokenAuth = &config.TokenAuthConfig{}
+		for _, value := range tokenAuthPaths {
+			instance.TokenAuth.Paths = append(instance.TokenAuth.Paths, splitList(value)...)
+		}
+	}
+
+	if signedURLs || len(signedURLPaths) > 0 {
+		instance.SignedURLs = &config.SignedURLConfig{}
+		for _, value := range signedURLPaths {
+			instance.SignedURLs.Paths = append(instance.SignedURLs.Paths, splitList(value)...)
+		}
+	}
+
+	if health || healthPath != "" || readyPath != "" {
+		instance.Health = &config.HealthConfig{
+			LivenessPath:  healthPath,
+			ReadinessPath: readyPath,
+		}
+	}
+
+	if tlsAuto {
+		if tlsCert != "" || tlsKey != "" {
+			fmt.Println("Error: -tls-auto cannot be combined with -tls-cert or -tls-key")
+			os.Exit(1)
+		}
+		instance.TLS = &config.TLSConfig{
+			Auto:         true,
+			Hosts:        splitList(tlsHosts),
+			MinVersion:   tlsMinVersion,
+			CipherSuites: splitList(tlsCiphers),
+			RedirectPort: tlsRedirectPort,
+		}
+	} else if tlsCert != "" || tlsKey != "" {
+		if tlsCert == "" || tlsKey == "" {
+			fmt.Println("Error: -tls-cert and -tls-key must be used together")
+			os.Exit(1)
+		}
+		instance.TLS = &config.TLSConfig{
+			CertFile:     tlsCert,
+			KeyFile:      tlsKey,
+			MinVersion:   tlsMinVersion,
+			CipherSuites: splitList(tlsCiphers),
+			RedirectPort: tlsRedirectPort,
+		}
+	} else if tlsMinVersion != "" || tlsCiphers != "" || tlsRedirectPort != 0 || tlsHosts != "" {
+		fmt.Println("Error: TLS options require -tls-auto or -tls-cert and -tls-key")
+		os.Exit(1)
+	}
+
+	if restartPolicy != config.RestartNever {
+		instance.Restart = &config.RestartPolicy{
+			Policy:      restartPolicy,
+			MaxRestarts: maxRestarts,
+			Backoff:     config.Duration(restartBackoff),
+			MaxBackoff:  config.Duration(restartMaxBackoff),
+		}
 	}
 
 	if err := manager.AddInstance(instance); err != nil {
@@ -133,157 +503,823 @@ func handleAdd(manager *server.Manager) {
 	}
 
 	fmt.Printf("Instance '%s' added successfully\n", name)
+
+	// Nobody can sign in until the htpasswd file has users
+	if added, err := manager.GetInstanceConfig(name); err == nil && added.BasicAuth != nil {
+		if users, err := server.HtpasswdUsers(added.BasicAuth.File); err != nil || len(users) == 0 {
+			fmt.Printf("Add users with: nanoHttp user add %s <user>\n", name)
+		}
+	}
 }
 
-func handleStart(manager *server.Manager) {
+func handleStart(manager *server.Manager, configDir string) {
 	startCmd := flag.NewFlagSet("start", flag.ExitOnError)
 	startCmd.Usage = func() {
 		fmt.Println("Usage: nanoHttp start <instance-name> [options]")
 		fmt.Println("\nOptions:")
-		fmt.Printf("  -f | -foreground            Run server in foreground (current process)\n")
+		fmt.Printf("  -f | -foreground            Run server in foreground (current process)\n")
+		fmt.Printf("  -log-output                 Write output to ~/.nanoHttp/logs/<name>/ instead of the terminal\n")
+		fmt.Printf("  -supervise                  Run a foreground server as a child process, restarting it\n")
+		fmt.Printf("                              according to the instance's restart policy\n")
+	}
+
+	var foreground, supervise, logOutput bool
+	startCmd.BoolVar(&foreground, "foreground", false, "")
+	startCmd.BoolVar(&foreground, "f", false, "")
+	startCmd.BoolVar(&supervise, "supervise", false, "")
+	startCmd.BoolVar(&logOutput, "log-output", false, "")
+
+	if len(os.Args) < 3 {
+		fmt.Println("Error: instance name is required")
+		startCmd.Usage()
+		os.Exit(1)
+	}
+
+	// Check if --help is requested
+	if os.Args[2] == "--help" || os.Args[2] == "-h" {
+		startCmd.Usage()
+		os.Exit(0)
+	}
+
+	name := os.Args[2]
+	if err := startCmd.Parse(os.Args[3:]); err != nil {
+		fmt.Printf("Error parsing flags: %v\n", err)
+		os.Exit(1)
+	}
+
+	if logOutput && (foreground || supervise) {
+		instance, err := manager.GetInstanceConfig(name)
+		if err != nil {
+			fmt.Printf("Error getting server instance: %v\n", err)
+			os.Exit(1)
+		}
+		if err := logfile.RedirectOutput(name, instance.Logs); err != nil {
+			fmt.Printf("Error redirecting output: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if foreground {
+		runServerInForeground(manager, name)
+	} else if supervise {
+		runServerSupervised(manager, name)
+	} else if client, err := daemon.Dial(configDir); err == nil {
+		status, err := client.Start(name)
+		if err != nil {
+			fmt.Printf("Error starting instance: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Instance '%s' started by daemon (PID %d)\n", name, status.PID)
+	} else {
+		if err := manager.StartInstance(name); err != nil {
+			fmt.Printf("Error starting instance: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Instance '%s' started successfully\n", name)
+	}
+}
+
+func runServerInForeground(manager *server.Manager, name string) {
+	instanceServer, err := manager.GetServer(name)
+	if err != nil {
+		fmt.Printf("Error getting server instance: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Set up signal handling
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	// Start the server
+	fmt.Printf("Starting server '%s' in foreground mode on port %d\n", name, instanceServer.GetConfig().Port)
+	if err := instanceServer.Serve(); err != nil {
+		fmt.Printf("Error starting server %s: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	// Other virtual hosts started on the same port are served here too, and
+	// picked up or dropped whenever the process is signaled to reload
+	hosted := map[string]*server.Server{name: instanceServer}
+	reloadSharedSites(instanceServer.GetConfig(), hosted)
+
+	// Wait for interrupt signal
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reloadSharedSites(instanceServer.GetConfig(), hosted)
+		if len(hosted) == 0 {
+			fmt.Println("No sites left to serve")
+			return
+		}
+	}
+	fmt.Printf("\nShutting down server '%s'...\n", name)
+
+	// Shutdown the server and any virtual hosts it took in, carrying on past
+	// failures so every site is stopped
+	failed := 0
+	for siteName, srv := range hosted {
+		if err := srv.Shutdown(context.Background()); err != nil {
+			fmt.Printf("Error shutting down server %s: %v\n", siteName, err)
+			failed++
+		}
+		if siteName != name {
+			markSiteStopped(siteName)
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d servers failed to shut down cleanly\n", failed, len(hosted))
+		os.Exit(1)
+	}
+	fmt.Println("Server stopped successfully")
+}
+
+// markSiteStopped records that a virtual host served by this process is no
+// longer running, since no stop command will
+func markSiteStopped(name string) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return
+	}
+	if instance, exists := cfg.GetInstance(name); exists && instance.IsRunning && instance.PID == os.Getpid() {
+		if err := config.SetInstanceStatus(name, false, 0); err != nil {
+			fmt.Printf("Error updating config: %v\n", err)
+		}
+	}
+}
+
+// reloadSharedSites brings the virtual hosts served by this process in line
+// with the configuration: sites started on the port of instance are served,
+// and sites that were stopped are shut down. The instance the process was
+// started for is only dropped once it has been stopped while sharing it.
+func reloadSharedSites(instance config.InstanceConfig, hosted map[string]*server.Server) {
+	if !instance.VirtualHost() {
+		return
+	}
+	sites, err := server.SharedSites(instance, os.Getpid(), os.Getppid())
+	if err != nil {
+		fmt.Printf("Error reloading sites: %v\n", err)
+		return
+	}
+
+	wanted := make(map[string]config.InstanceConfig)
+	for _, site := range sites {
+		wanted[site.Name] = site
+	}
+	_, hosting := hosted[instance.Name]
+	if _, listed := wanted[instance.Name]; hosting && !listed {
+		wanted[instance.Name] = instance
+		if cfg, err := config.LoadConfig(); err == nil && len(wanted) > 1 {
+			if own, exists := cfg.GetInstance(instance.Name); exists && !own.IsRunning {
+				delete(wanted, instance.Name)
+			}
+		}
+	}
+
+	for name, srv := range hosted {
+		if _, ok := wanted[name]; ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := srv.Shutdown(ctx); err != nil {
+			fmt.Printf("Error shutting down server %s: %v\n", name, err)
+		}
+		cancel()
+		delete(hosted, name)
+		fmt.Printf("Stopped serving '%s'\n", name)
+	}
+
+	for name, site := range wanted {
+		if _, ok := hosted[name]; ok {
+			continue
+		}
+		srv := server.NewServer(site)
+		if err := srv.Serve(); err != nil {
+			fmt.Printf("Error starting server %s: %v\n", name, err)
+			continue
+		}
+		hosted[name] = srv
+		fmt.Printf("Serving '%s' on port %d\n", name, site.Port)
+	}
+}
+
+// pipedOutput writes to one of this process's output files. Handed to exec
+// instead of the file itself, it makes exec copy the child's output through a
+// pipe: an inherited descriptor would keep pointing at the log file the child
+// started with, while writes through this process follow the log file that
+// logfile.RedirectOutput moves onto the descriptor on rotation. The pipe is
+// also drained when the child crashes.
+type pipedOutput struct {
+	file *os.File
+}
+
+func (p pipedOutput) Write(b []byte) (int, error) {
+	return p.file.Write(b)
+}
+
+// runServerSupervised runs the instance as a 'start -foreground' child process
+// and restarts it according to its restart policy until this process is
+// signaled to stop
+func runServerSupervised(manager *server.Manager, name string) {
+	srv, err := manager.GetServer(name)
+	if err != nil {
+		fmt.Printf("Error getting server instance: %v\n", err)
+		os.Exit(1)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Printf("Error getting executable path: %v\n", err)
+		os.Exit(1)
+	}
+
+	var (
+		mu    sync.Mutex
+		child *os.Process
+	)
+	stop := make(chan struct{})
+
+	// Forward termination to the child and stop restarting it. Reload
+	// requests are passed on as they are.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range sigChan {
+			if sig != syscall.SIGHUP {
+				break
+			}
+			mu.Lock()
+			if child != nil {
+				child.Signal(syscall.SIGHUP)
+			}
+			mu.Unlock()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		close(stop)
+		if child != nil {
+			child.Signal(syscall.SIGTERM)
+		}
+	}()
+
+	run := func() error {
+		mu.Lock()
+		select {
+		case <-stop:
+			mu.Unlock()
+			return nil
+		default:
+		}
+		cmd := exec.Command(executable, "start", name, "-foreground")
+		cmd.Stdout = pipedOutput{os.Stdout}
+		cmd.Stderr = pipedOutput{os.Stderr}
+		err := cmd.Start()
+		if err == nil {
+			child = cmd.Process
+		}
+		mu.Unlock()
+		if err != nil {
+			return err
+		}
+		return cmd.Wait()
+	}
+
+	onExit := func(restarts int, err error, restarting bool) {
+		reason := supervisor.ExitReason(err)
+		if restarting {
+			fmt.Printf("Server '%s' exited (%s), restarting (attempt %d)\n", name, reason, restarts)
+		} else {
+			fmt.Printf("Server '%s' exited (%s), giving up after %d restarts\n", name, reason, restarts)
+		}
+		if err := config.RecordExit(name, restarts, reason); err != nil {
+			fmt.Printf("Error updating config: %v\n", err)
+		}
+	}
+
+	policy := supervisor.NewPolicy(srv.GetConfig().Restart)
+	supervisor.Run(policy, stop, run, onExit)
+
+	// Only clear the status when the supervisor gave up on its own; a stop
+	// command has already updated it
+	select {
+	case <-stop:
+	default:
+		if err := config.SetInstanceStatus(name, false, 0); err != nil {
+			fmt.Printf("Error updating config: %v\n", err)
+		}
+	}
+}
+
+func handleStop(manager *server.Manager, configDir string) {
+	stopCmd := flag.NewFlagSet("stop", flag.ExitOnError)
+	stopCmd.Usage = func() {
+		fmt.Println("Usage: nanoHttp stop <instance-name>")
+		fmt.Println("\nDescription:")
+		fmt.Println("  Stop a running server instance. If the instance is not running,")
+		fmt.Println("  an error will be returned. This command will gracefully shutdown")
+		fmt.Println("  the server, allowing in-flight requests to complete.")
+	}
+
+	// Handle --help explicitly
+	if len(os.Args) > 2 && (os.Args[2] == "--help" || os.Args[2] == "-h") {
+		stopCmd.Usage()
+		os.Exit(0)
+	}
+
+	if len(os.Args) < 3 {
+		fmt.Println("Error: instance name is required")
+		stopCmd.Usage()
+		os.Exit(1)
+	}
+
+	name := os.Args[2]
+	if client, err := daemon.Dial(configDir); err == nil {
+		if _, err := client.Stop(name); err != nil {
+			fmt.Printf("Error stopping instance: %v\n", err)
+			os.Exit(1)
+		}
+	} else if err := manager.StopInstance(name); err != nil {
+		fmt.Printf("Error stopping instance: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Instance '%s' stopped successfully\n", name)
+}
+
+func handleDelete(manager *server.Manager, configDir string) {
+	deleteCmd := flag.NewFlagSet("delete", flag.ExitOnError)
+	deleteCmd.Usage = func() {
+		fmt.Println("Usage: nanoHttp delete <instance-name>")
+		fmt.Println("\nDescription:")
+		fmt.Println("  Delete a server instance configuration. If the instance is running,")
+		fmt.Println("  it will be stopped first. This command will remove all configuration")
+		fmt.Println("  data for the specified instance. This action cannot be undone.")
+	}
+
+	// Handle --help explicitly
+	if len(os.Args) > 2 && (os.Args[2] == "--help" || os.Args[2] == "-h") {
+		deleteCmd.Usage()
+		os.Exit(0)
+	}
+
+	if len(os.Args) < 3 {
+		fmt.Println("Error: instance name is required")
+		deleteCmd.Usage()
+		os.Exit(1)
+	}
+
+	name := os.Args[2]
+	if err := manager.DeleteInstance(name); err != nil {
+		fmt.Printf("Error deleting instance: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Let a running daemon stop serving the deleted instance
+	if client, err := daemon.Dial(configDir); err == nil {
+		if _, err := client.Reload(); err != nil {
+			fmt.Printf("Error notifying daemon: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Instance '%s' deleted successfully\n", name)
+}
+
+func handleDaemon(configDir string) {
+	daemonCmd := flag.NewFlagSet("daemon", flag.ExitOnError)
+	daemonCmd.Usage = func() {
+		fmt.Println("Usage: nanoHttp daemon [options]")
+		fmt.Println("\nDescription:")
+		fmt.Println("  Run every configured instance inside a single supervisor process.")
+		fmt.Println("  While the daemon is running, start, stop and delete are applied by")
+		fmt.Println("  the daemon instead of spawning a process per instance.")
+		fmt.Println("\nOptions:")
+		fmt.Printf("  -d | -detach                Run the daemon in the background\n")
+		fmt.Printf("  -stop                       Stop a running daemon and all its instances\n")
+	}
+
+	var detach, stop bool
+	daemonCmd.BoolVar(&detach, "detach", false, "")
+	daemonCmd.BoolVar(&detach, "d", false, "")
+	daemonCmd.BoolVar(&stop, "stop", false, "")
+
+	// Handle --help explicitly
+	if len(os.Args) > 2 && (os.Args[2] == "--help" || os.Args[2] == "-h") {
+		daemonCmd.Usage()
+		os.Exit(0)
+	}
+
+	daemonCmd.Parse(os.Args[2:])
+
+	if stop {
+		if err := daemon.Stop(configDir); err != nil {
+			fmt.Printf("Error stopping daemon: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Daemon stopped successfully")
+		return
+	}
+
+	if detach {
+		if pid, running := daemon.IsRunning(configDir); running {
+			fmt.Printf("Error starting daemon: daemon is already running (PID %d)\n", pid)
+			os.Exit(1)
+		}
+
+		executable, err := os.Executable()
+		if err != nil {
+			fmt.Printf("Error getting executable path: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Start the daemon in a new session so it outlives the terminal
+		cmd := exec.Command(executable, "daemon")
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		if err := cmd.Start(); err != nil {
+			fmt.Printf("Error starting daemon: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Daemon started successfully (PID %d)\n", cmd.Process.Pid)
+		return
+	}
+
+	if err := daemon.New(configDir).Run(); err != nil {
+		fmt.Printf("Error running daemon: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func handleCA(configDir string) {
+	caCmd := flag.NewFlagSet("ca", flag.ExitOnError)
+	caCmd.Usage = func() {
+		fmt.Println("Usage: nanoHttp ca export [options]")
+		fmt.Println("\nDescription:")
+		fmt.Println("  Print the local root CA certificate that signs -tls-auto certificates,")
+		fmt.Println("  so it can be trusted in browsers and the system trust store. The CA is")
+		fmt.Println("  created on first use.")
+		fmt.Println("\nOptions:")
+		fmt.Printf("  -o | -output                Write the certificate to a file instead of stdout\n")
+	}
+
+	var output string
+	caCmd.StringVar(&output, "output", "", "")
+	caCmd.StringVar(&output, "o", "", "")
+
+	// Handle --help explicitly
+	if len(os.Args) > 2 && (os.Args[2] == "--help" || os.Args[2] == "-h") {
+		caCmd.Usage()
+		os.Exit(0)
+	}
+
+	if len(os.Args) < 3 || os.Args[2] != "export" {
+		caCmd.Usage()
+		os.Exit(1)
+	}
+
+	caCmd.Parse(os.Args[3:])
+
+	authority, err := ca.LoadOrCreate(configDir)
+	if err != nil {
+		fmt.Printf("Error loading CA: %v\n", err)
+		os.Exit(1)
+	}
+
+	if output == "" {
+		fmt.Print(string(authority.CertPEM()))
+		return
+	}
+
+	if err := os.WriteFile(output, authority.CertPEM(), 0644); err != nil {
+		fmt.Printf("Error writing certificate: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Root CA certificate written to %s\n", output)
+}
+
+func handleUser(manager *server.Manager) {
+	userCmd := flag.NewFlagSet("user", flag.ExitOnError)
+	userCmd.Usage = func() {
+		fmt.Println("Usage: nanoHttp user add|remove <instance-name> <user>")
+		fmt.Println("       nanoHttp user list <instance-name>")
+		fmt.Println("\nDescription:")
+		fmt.Println("  Manage the users of an instance added with -auth or -auth-file. Passwords")
+		fmt.Println("  are stored as bcrypt hashes in the instance's htpasswd file. add asks for")
+		fmt.Println("  the password, or reads it from standard input when that is not a terminal,")
+		fmt.Println("  and changes the password of an existing user. Running instances pick up")
+		fmt.Println("  changes without a restart.")
+	}
+
+	// Handle --help explicitly
+	if len(os.Args) > 2 && (os.Args[2] == "--help" || os.Args[2] == "-h") {
+		userCmd.Usage()
+		os.Exit(0)
+	}
+
+	if len(os.Args) < 4 {
+		userCmd.Usage()
+		os.Exit(1)
+	}
+
+	action, name := os.Args[2], os.Args[3]
+	instance, err := manager.GetInstanceConfig(name)
+	if err != nil {
+		fmt.Printf("Error getting server instance: %v\n", err)
+		os.Exit(1)
+	}
+	if instance.BasicAuth == nil {
+		fmt.Printf("Error: instance '%s' does not use Basic auth (add it with -auth)\n", name)
+		os.Exit(1)
+	}
+	file := instance.BasicAuth.File
+
+	switch action {
+	case "list":
+		users, err := server.HtpasswdUsers(file)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Error reading users: %v\n", err)
+			os.Exit(1)
+		}
+		if len(users) == 0 {
+			fmt.Printf("Instance '%s' has no users\n", name)
+			return
+		}
+		sort.Strings(users)
+		for _, user := range users {
+			fmt.Println(user)
+		}
+		return
+	case "add", "remove":
+	default:
+		fmt.Printf("Unknown user command: %s\n", action)
+		userCmd.Usage()
+		os.Exit(1)
+	}
+
+	if len(os.Args) < 5 {
+		fmt.Println("Error: user name is required")
+		userCmd.Usage()
+		os.Exit(1)
+	}
+	user := os.Args[4]
+
+	if action == "remove" {
+		if err := server.RemoveHtpasswdUser(file, user); err != nil {
+			fmt.Printf("Error removing user: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("User '%s' removed from instance '%s'\n", user, name)
+		return
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	existed, err := server.SetHtpasswdUser(file, user, password)
+	if err != nil {
+		fmt.Printf("Error adding user: %v\n", err)
+		os.Exit(1)
+	}
+	if existed {
+		fmt.Printf("Password of user '%s' changed for instance '%s'\n", user, name)
+	} else {
+		fmt.Printf("User '%s' added to instance '%s'\n", user, name)
+	}
+}
+
+// readPassword asks for a new password twice without echoing it, or reads a
+// line from standard input when it is not a terminal
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("error reading password: %v", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Print("Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("error reading password: %v", err)
+	}
+	fmt.Print("Confirm password: ")
+	confirm, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("error reading password: %v", err)
+	}
+	if string(password) != string(confirm) {
+		return "", fmt.Errorf("passwords do not match")
+	}
+	return string(password), nil
+}
+
+func handleToken(manager *server.Manager) {
+	tokenCmd := flag.NewFlagSet("token", flag.ExitOnError)
+	tokenCmd.Usage = func() {
+		fmt.Println("Usage: nanoHttp token create <instance-name> <token-name> [options]")
+		fmt.Println("       nanoHttp token revoke <instance-name> <token-name>")
+		fmt.Println("       nanoHttp token list <instance-name>")
+		fmt.Println("\nDescription:")
+		fmt.Println("  Manage the tokens of an instance added with -token-auth. A token is only")
+		fmt.Println("  shown when it is created; the config keeps a hash of it. Clients send it")
+		fmt.Printf("  as \"Authorization: Bearer <token>\", in the %s header or in the\n", server.APIKeyHeader)
+		fmt.Printf("  %s query parameter. Running instances pick up changes without a\n", server.TokenQueryParam)
+		fmt.Println("  restart.")
+		fmt.Println("\nOptions:")
+		fmt.Printf("  -expires                    Expire the token after a duration (e.g. 720h or 30d) or at a date\n")
+		fmt.Printf("                              (2006-01-02 or RFC 3339); default never\n")
+		fmt.Printf("  -path                       Comma-separated path prefixes the token may access (repeatable,\n")
+		fmt.Printf("                              default all protected paths)\n")
 	}
 
-	var foreground bool
-	startCmd.BoolVar(&foreground, "foreground", false, "")
-	startCmd.BoolVar(&foreground, "f", false, "")
-
-	if len(os.Args) < 3 {
-		fmt.Println("Error: instance name is required")
-		startCmd.Usage()
-		os.Exit(1)
-	}
+	var (
+		expires string
+		paths   listFlag
+	)
+	tokenCmd.StringVar(&expires, "expires", "", "")
+	tokenCmd.Var(&paths, "path", "")
 
-	// Check if --help is requested
-	if os.Args[2] == "--help" || os.Args[2] == "-h" {
-		startCmd.Usage()
+	// Handle --help explicitly
+	if len(os.Args) > 2 && (os.Args[2] == "--help" || os.Args[2] == "-h") {
+		tokenCmd.Usage()
 		os.Exit(0)
 	}
 
-	name := os.Args[2]
-	if err := startCmd.Parse(os.Args[3:]); err != nil {
-		fmt.Printf("Error parsing flags: %v\n", err)
+	if len(os.Args) < 4 {
+		tokenCmd.Usage()
 		os.Exit(1)
 	}
 
-	if foreground {
-		runServerInForeground(manager, name)
-	} else {
-		if err := manager.StartInstance(name); err != nil {
-			fmt.Printf("Error starting instance: %v\n", err)
+	action, name := os.Args[2], os.Args[3]
+	switch action {
+	case "list":
+		instance, err := manager.GetInstanceConfig(name)
+		if err != nil {
+			fmt.Printf("Error getting server instance: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("Instance '%s' started successfully\n", name)
+		if instance.TokenAuth == nil || len(instance.TokenAuth.Tokens) == 0 {
+			fmt.Printf("Instance '%s' has no tokens\n", name)
+			return
+		}
+		now := time.Now()
+		fmt.Printf("%-20s %-30s %-12s %s\n", "NAME", "PATHS", "CREATED", "EXPIRES")
+		for _, t := range instance.TokenAuth.Tokens {
+			scope := "all"
+			if len(t.Paths) > 0 {
+				scope = strings.Join(t.Paths, ",")
+			}
+			expiry := "never"
+			if t.Expires != nil {
+				expiry = t.Expires.Local().Format("2006-01-02 15:04")
+				if t.Expired(now) {
+					expiry += " (expired)"
+				}
+			}
+			fmt.Printf("%-20s %-30s %-12s %s\n", truncateString(t.Name, 20), truncateString(scope, 30),
+				t.Created.Local().Format("2006-01-02"), expiry)
+		}
+		return
+	case "create", "revoke":
+	default:
+		fmt.Printf("Unknown token command: %s\n", action)
+		tokenCmd.Usage()
+		os.Exit(1)
 	}
-}
 
-func runServerInForeground(manager *server.Manager, name string) {
-	server, err := manager.GetServer(name)
-	if err != nil {
-		fmt.Printf("Error getting server instance: %v\n", err)
+	if len(os.Args) < 5 {
+		fmt.Println("Error: token name is required")
+		tokenCmd.Usage()
 		os.Exit(1)
 	}
+	tokenName := os.Args[4]
 
-	// Create a new context for the server
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Set up signal handling
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	if action == "revoke" {
+		if err := manager.RevokeToken(name, tokenName); err != nil {
+			fmt.Printf("Error revoking token: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Token '%s' revoked for instance '%s'\n", tokenName, name)
+		return
+	}
 
-	// Create the HTTP server
-	httpServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", server.GetConfig().Port),
-		Handler: server.CreateHandler(),
-		BaseContext: func(l net.Listener) context.Context {
-			return ctx
-		},
+	if err := tokenCmd.Parse(os.Args[5:]); err != nil {
+		fmt.Printf("Error parsing flags: %v\n", err)
+		os.Exit(1)
 	}
 
-	// Start the server
-	go func() {
-		fmt.Printf("Starting server '%s' in foreground mode on port %d\n", name, server.GetConfig().Port)
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Printf("Error starting server %s: %v\n", name, err)
+	var expiresAt time.Time
+	if expires != "" {
+		var err error
+		if expiresAt, err = parseExpiry(expires); err != nil {
+			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
-	}()
+		if !expiresAt.After(time.Now()) {
+			fmt.Println("Error: -expires must be in the future")
+			os.Exit(1)
+		}
+	}
 
-	// Wait for interrupt signal
-	<-sigChan
-	fmt.Printf("\nShutting down server '%s'...\n", name)
+	var scope []string
+	for _, value := range paths {
+		scope = append(scope, splitList(value)...)
+	}
 
-	// Shutdown the server
-	if err := httpServer.Shutdown(context.Background()); err != nil {
-		fmt.Printf("Error shutting down server: %v\n", err)
+	token, err := manager.CreateToken(name, tokenName, scope, expiresAt)
+	if err != nil {
+		fmt.Printf("Error creating token: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Println("Server stopped successfully")
+	fmt.Fprintf(os.Stderr, "Token '%s' created for instance '%s'. Store it now, it can't be shown again:\n", tokenName, name)
+	fmt.Println(token)
 }
 
-func handleStop(manager *server.Manager) {
-	stopCmd := flag.NewFlagSet("stop", flag.ExitOnError)
-	stopCmd.Usage = func() {
-		fmt.Println("Usage: nanoHttp stop <instance-name>")
-		fmt.Println("\nDescription:")
-		fmt.Println("  Stop a running server instance. If the instance is not running,")
-		fmt.Println("  an error will be returned. This command will gracefully shutdown")
-		fmt.Println("  the server, allowing in-flight requests to complete.")
+// parseExpiry parses a token expiry given as a duration from now, a number of
+// days such as "30d", a date or an RFC 3339 time
+func parseExpiry(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(d), nil
 	}
-
-	// Handle --help explicitly
-	if len(os.Args) > 2 && (os.Args[2] == "--help" || os.Args[2] == "-h") {
-		stopCmd.Usage()
-		os.Exit(0)
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Now().AddDate(0, 0, n), nil
+		}
 	}
-
-	if len(os.Args) < 3 {
-		fmt.Println("Error: instance name is required")
-		stopCmd.Usage()
-		os.Exit(1)
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
 	}
-
-	name := os.Args[2]
-	if err := manager.StopInstance(name); err != nil {
-		fmt.Printf("Error stopping instance: %v\n", err)
-		os.Exit(1)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
 	}
-
-	fmt.Printf("Instance '%s' stopped successfully\n", name)
+	return time.Time{}, fmt.Errorf("invalid -expires value %q (expected a duration, a number of days, a date or an RFC 3339 time)", value)
 }
 
-func handleDelete(manager *server.Manager) {
-	deleteCmd := flag.NewFlagSet("delete", flag.ExitOnError)
-	deleteCmd.Usage = func() {
-		fmt.Println("Usage: nanoHttp delete <instance-name>")
+func handleSign(manager *server.Manager) {
+	signCmd := flag.NewFlagSet("sign", flag.ExitOnError)
+	signCmd.Usage = func() {
+		fmt.Println("Usage: nanoHttp sign <instance-name> <path> [options]")
 		fmt.Println("\nDescription:")
-		fmt.Println("  Delete a server instance configuration. If the instance is running,")
-		fmt.Println("  it will be stopped first. This command will remove all configuration")
-		fmt.Println("  data for the specified instance. This action cannot be undone.")
+		fmt.Println("  Print a URL for a path of an instance added with -signed-urls. The URL")
+		fmt.Println("  works without other credentials until it expires, and only for that path.")
+		fmt.Println("\nOptions:")
+		fmt.Printf("  -base-url                   Address the instance is reached at, e.g. https://files.example.com\n")
+		fmt.Printf("                              (default its local address)\n")
+		fmt.Printf("  -ttl                        How long the URL stays valid (default 1h)\n")
 	}
 
+	var (
+		ttl     time.Duration
+		baseURL string
+	)
+	signCmd.DurationVar(&ttl, "ttl", time.Hour, "")
+	signCmd.StringVar(&baseURL, "base-url", "", "")
+
 	// Handle --help explicitly
 	if len(os.Args) > 2 && (os.Args[2] == "--help" || os.Args[2] == "-h") {
-		deleteCmd.Usage()
+		signCmd.Usage()
 		os.Exit(0)
 	}
 
-	if len(os.Args) < 3 {
-		fmt.Println("Error: instance name is required")
-		deleteCmd.Usage()
+	if len(os.Args) < 4 {
+		fmt.Println("Error: instance name and path are required")
+		signCmd.Usage()
 		os.Exit(1)
 	}
 
-	name := os.Args[2]
-	if err := manager.DeleteInstance(name); err != nil {
-		fmt.Printf("Error deleting instance: %v\n", err)
+	name, urlPath := os.Args[2], os.Args[3]
+	if err := signCmd.Parse(os.Args[4:]); err != nil {
+		fmt.Printf("Error parsing flags: %v\n", err)
+		os.Exit(1)
+	}
+	if ttl <= 0 {
+		fmt.Println("Error: -ttl must be positive")
 		os.Exit(1)
 	}
 
-	fmt.Printf("Instance '%s' deleted successfully\n", name)
+	instance, err := manager.GetInstanceConfig(name)
+	if err != nil {
+		fmt.Printf("Error getting server instance: %v\n", err)
+		os.Exit(1)
+	}
+
+	signed, err := server.SignURL(instance, urlPath, time.Now().Add(ttl), baseURL)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(signed)
 }
 
-func handleList(manager *server.Manager) {
+func handleList(manager *server.Manager, configDir string) {
 	listCmd := flag.NewFlagSet("list", flag.ExitOnError)
 	listCmd.Usage = func() {
 		fmt.Println("Usage: nanoHttp list [options]")
@@ -309,6 +1345,26 @@ func handleList(manager *server.Manager) {
 		return
 	}
 
+	// A running daemon knows the real state of the instances it hosts
+	stats := make(map[string]*server.Stats)
+	if client, err := daemon.Dial(configDir); err == nil {
+		statuses, err := client.List()
+		if err != nil {
+			fmt.Printf("Error querying daemon: %v\n", err)
+			os.Exit(1)
+		}
+		running := make(map[string]daemon.InstanceStatus, len(statuses))
+		for _, status := range statuses {
+			running[status.Name] = status
+		}
+		for _, instance := range instances {
+			status := running[instance.Name]
+			instance.IsRunning = status.Running
+			instance.PID = status.PID
+			stats[instance.Name] = status.Stats
+		}
+	}
+
 	if simpleFormat {
 		// Simple format display
 		fmt.Println("Server Instances:")
@@ -332,10 +1388,242 @@ func handleList(manager *server.Manager) {
 
 			fmt.Printf("Name: %s\n", instance.Name)
 			fmt.Printf("  Port: %d\n", instance.Port)
+			if len(instance.Hosts) > 0 || instance.DefaultHost {
+				hosts := strings.Join(instance.Hosts, ", ")
+				if instance.DefaultHost {
+					hosts = strings.TrimPrefix(hosts+", any other host (default)", ", ")
+				}
+				fmt.Printf("  Hosts: %s\n", hosts)
+			}
 			fmt.Printf("  Web Folder: %s\n", instance.WebFolder)
 			fmt.Printf("  Dir Listing: %s\n", dirListing)
+			if instance.SPA {
+				fallback := instance.SPAFallback
+				if fallback == "" {
+					fallback = config.DefaultSPAFallback
+				}
+				fmt.Printf("  SPA Fallback: %s\n", fallback)
+			}
+			var siteFiles []string
+			for _, name := range []string{server.RedirectsFile, server.HeadersFile} {
+				if info, err := os.Stat(filepath.Join(instance.WebFolder, name)); err == nil && !info.IsDir() {
+					siteFiles = append(siteFiles, name)
+				}
+			}
+			if len(siteFiles) > 0 {
+				fmt.Printf("  Site Files: %s\n", strings.Join(siteFiles, ", "))
+			}
+			for _, mount := range instance.Mounts {
+				var options []string
+				if mount.AllowDirListing {
+					options = append(options, "listing")
+				}
+				if fallback := mount.SPAFallbackFile(); fallback != "" {
+					options = append(options, "SPA fallback "+fallback)
+				}
+				suffix := ""
+				if len(options) > 0 {
+					suffix = " (" + strings.Join(options, ", ") + ")"
+				}
+				fmt.Printf("  Mount: %s -> %s%s\n", mount.Prefix, mount.Folder, suffix)
+			}
+			if len(instance.ErrorPages) > 0 {
+				codes := make([]int, 0, len(instance.ErrorPages))
+				for code := range instance.ErrorPages {
+					codes = append(codes, code)
+				}
+				sort.Ints(codes)
+				for _, code := range codes {
+					fmt.Printf("  Error Page %d: %s\n", code, instance.ErrorPages[code])
+				}
+			}
+			if instance.Compression != nil {
+				encodings := "br,gzip"
+				if len(instance.Compression.Encodings) > 0 {
+					encodings = strings.Join(instance.Compression.Encodings, ",")
+				}
+				fmt.Printf("  Compression: %s\n", encodings)
+			}
+			if instance.AccessLog != nil {
+				format := instance.AccessLog.Format
+				if format == "" {
+					format = server.LogFormatCombined
+				}
+				if path, err := server.AccessLogPath(instance.Name, instance.AccessLog); err == nil {
+					fmt.Printf("  Access Log: %s (%s)\n", path, format)
+				}
+			}
+			if instance.Logs != nil {
+				opts := logfile.OptionsFrom(instance.Logs)
+				rotation := fmt.Sprintf("%d MB", opts.MaxSize>>20)
+				if opts.MaxAge > 0 {
+					rotation += fmt.Sprintf(" or %s", opts.MaxAge)
+				}
+				if opts.Compress {
+					rotation += ", gzipped"
+				}
+				fmt.Printf("  Log Rotation: %s, keep %d\n", rotation, opts.MaxFiles)
+			}
+			// Upstream state comes from the daemon, or from the state file a
+			// standalone server process publishes
+			var upstreams []server.UpstreamStatus
+			if st := stats[instance.Name]; st != nil {
+				upstreams = st.Upstreams
+			} else if instance.IsRunning && len(instance.Proxies) > 0 {
+				upstreams, _ = server.ReadUpstreamState(instance.Name)
+			}
+			for _, route := range instance.Proxies {
+				var options []string
+				if len(route.Targets()) > 1 {
+					strategy := route.Strategy
+					if strategy == "" {
+						strategy = server.StrategyRoundRobin
+					}
+					options = append(options, strategy)
+				}
+				if route.Retries > 0 {
+					options = append(options, fmt.Sprintf("%d retries", route.Retries))
+				}
+				if route.HealthCheck != nil {
+					options = append(options, "health checked")
+				}
+				if route.StripPrefix {
+					options = append(options, "strip")
+				}
+				if route.PreserveHost {
+					options = append(options, "preserve-host")
+				}
+				suffix := ""
+				if len(options) > 0 {
+					suffix = " (" + strings.Join(options, ", ") + ")"
+				}
+				fmt.Printf("  Proxy: %s -> %s%s\n", route.Prefix, strings.Join(route.Targets(), ", "), suffix)
+				for _, upstream := range upstreams {
+					if upstream.Route != route.Prefix {
+						continue
+					}
+					state := "healthy"
+					if !upstream.Healthy {
+						state = "ejected"
+					}
+					if upstream.LastError != "" {
+						state += " (" + upstream.LastError + ")"
+					}
+					fmt.Printf("    Upstream %s: %s, %d active\n", upstream.URL, state, upstream.Active)
+				}
+			}
+			for _, rule := range instance.Rules {
+				action := "rewrite"
+				if rule.Status != 0 {
+					action = fmt.Sprintf("redirect %d", rule.Status)
+				}
+				var conditions []string
+				if len(rule.Methods) > 0 {
+					conditions = append(conditions, strings.Join(rule.Methods, ","))
+				}
+				for header, pattern := range rule.Headers {
+					conditions = append(conditions, header+" ~ "+pattern)
+				}
+				if len(conditions) > 0 {
+					action += ", if " + strings.Join(conditions, " and ")
+				}
+				fmt.Printf("  Rule: %s -> %s (%s)\n", rule.Match, rule.Target, action)
+			}
+			if instance.Metrics != nil {
+				metricsPath := instance.Metrics.Path
+				if metricsPath == "" {
+					metricsPath = server.DefaultMetricsPath
+				}
+				metricsPort := instance.Metrics.Port
+				if metricsPort == 0 {
+					metricsPort = instance.Port
+				}
+				fmt.Printf("  Metrics: :%d%s\n", metricsPort, metricsPath)
+			}
+			if instance.Health != nil {
+				liveness, readiness := instance.Health.LivenessPath, instance.Health.ReadinessPath
+				if liveness == "" {
+					liveness = server.DefaultLivenessPath
+				}
+				if readiness == "" {
+					readiness = server.DefaultReadinessPath
+				}
+				fmt.Printf("  Health Checks: %s, %s\n", liveness, readiness)
+			}
+			if instance.IPFilter != nil {
+				if len(instance.IPFilter.Allow) > 0 {
+					fmt.Printf("  Allow: %s\n", strings.Join(instance.IPFilter.Allow, ", "))
+				}
+				if len(instance.IPFilter.Deny) > 0 {
+					fmt.Printf("  Deny: %s\n", strings.Join(instance.IPFilter.Deny, ", "))
+				}
+				if len(instance.IPFilter.TrustedProxies) > 0 {
+					fmt.Printf("  Trusted Proxies: %s\n", strings.Join(instance.IPFilter.TrustedProxies, ", "))
+				}
+			}
+			if limit := instance.RateLimit; limit != nil {
+				if limit.Rate > 0 {
+					by, burst := limit.By, limit.Burst
+					if by == "" {
+						by = server.RateLimitByIP
+					}
+					if burst == 0 {
+						burst = int(math.Ceil(limit.Rate))
+					}
+					fmt.Printf("  Rate Limit: %g/s per %s, burst %d\n", limit.Rate, by, burst)
+				}
+				if limit.MaxRequests > 0 {
+					fmt.Printf("  Max Concurrent Requests: %d\n", limit.MaxRequests)
+				}
+			}
+			if instance.BasicAuth != nil {
+				scope := "whole site"
+				if len(instance.BasicAuth.Paths) > 0 {
+					scope = strings.Join(instance.BasicAuth.Paths, ", ")
+				}
+				fmt.Printf("  Basic Auth: %s (users in %s)\n", scope, instance.BasicAuth.File)
+			}
+			if instance.TokenAuth != nil {
+				scope := "whole site"
+				if len(instance.TokenAuth.Paths) > 0 {
+					scope = strings.Join(instance.TokenAuth.Paths, ", ")
+				}
+				fmt.Printf("  Token Auth: %s (%d tokens)\n", scope, len(instance.TokenAuth.Tokens))
+			}
+			if instance.SignedURLs != nil {
+				scope := "whole site"
+				if len(instance.SignedURLs.Paths) > 0 {
+					scope = strings.Join(instance.SignedURLs.Paths, ", ")
+				}
+				fmt.Printf("  Signed URLs: %s\n", scope)
+			}
+			if instance.TLS != nil {
+				if instance.TLS.Auto {
+					fmt.Printf("  HTTPS: yes (local CA certificate %s)\n", instance.TLS.CertFile)
+				} else {
+					fmt.Printf("  HTTPS: yes (certificate %s)\n", instance.TLS.CertFile)
+				}
+				if instance.TLS.RedirectPort > 0 {
+					fmt.Printf("  HTTP Redirect Port: %d\n", instance.TLS.RedirectPort)
+				}
+			}
 			fmt.Printf("  Status: %s%s\033[0m\n", statusColor, status)
-			fmt.Printf("  PID: %s\n\n", pid)
+			fmt.Printf("  PID: %s\n", pid)
+			if instance.Restart != nil {
+				fmt.Printf("  Restart Policy: %s (max %d)\n", instance.Restart.Policy, instance.Restart.MaxRestarts)
+			}
+			if instance.Restarts > 0 {
+				fmt.Printf("  Restarts: %d\n", instance.Restarts)
+			}
+			if instance.LastExit != "" {
+				fmt.Printf("  Last Exit: %s\n", instance.LastExit)
+			}
+			if st := stats[instance.Name]; st != nil {
+				fmt.Printf("  Uptime: %s\n", st.Uptime)
+				fmt.Printf("  Requests: %d (%d in flight)\n", st.Requests, st.InFlight)
+				fmt.Printf("  Bytes Sent: %d\n", st.BytesSent)
+			}
+			fmt.Println()
 		}
 		return
 	}
@@ -347,33 +1635,37 @@ func handleList(manager *server.Manager) {
 		dirWidth    = 12
 		statusWidth = 8
 		pidWidth    = 8
+		restWidth   = 8
 	)
 
 	// Print table header
 	fmt.Println("Server Instances:")
-	fmt.Printf("┌%s┬%s┬%s┬%s┬%s┬%s┐\n",
+	fmt.Printf("┌%s┬%s┬%s┬%s┬%s┬%s┬%s┐\n",
 		strings.Repeat("─", nameWidth+2),
 		strings.Repeat("─", portWidth+2),
 		strings.Repeat("─", folderWidth+2),
 		strings.Repeat("─", dirWidth+2),
 		strings.Repeat("─", statusWidth+2),
-		strings.Repeat("─", pidWidth+2))
+		strings.Repeat("─", pidWidth+2),
+		strings.Repeat("─", restWidth+2))
 
-	fmt.Printf("│ %-*s │ %-*s │ %-*s │ %-*s │ %-*s │ %-*s │\n",
+	fmt.Printf("│ %-*s │ %-*s │ %-*s │ %-*s │ %-*s │ %-*s │ %-*s │\n",
 		nameWidth, "Name",
 		portWidth, "Port",
 		folderWidth, "Web Folder",
 		dirWidth, "Dir Listing",
 		statusWidth, "Status",
-		pidWidth, "PID")
+		pidWidth, "PID",
+		restWidth, "Restarts")
 
-	fmt.Printf("├%s┼%s┼%s┼%s┼%s┼%s┤\n",
+	fmt.Printf("├%s┼%s┼%s┼%s┼%s┼%s┼%s┤\n",
 		strings.Repeat("─", nameWidth+2),
 		strings.Repeat("─", portWidth+2),
 		strings.Repeat("─", folderWidth+2),
 		strings.Repeat("─", dirWidth+2),
 		strings.Repeat("─", statusWidth+2),
-		strings.Repeat("─", pidWidth+2))
+		strings.Repeat("─", pidWidth+2),
+		strings.Repeat("─", restWidth+2))
 
 	// Print each instance as a table row
 	for _, instance := range instances {
@@ -397,22 +1689,281 @@ func handleList(manager *server.Manager) {
 			pid = fmt.Sprintf("%d", instance.PID)
 		}
 
-		fmt.Printf("│ %-*s │ %*d │ %-*s │ %-*s │ %s%-*s\033[0m │ %-*s │\n",
+		fmt.Printf("│ %-*s │ %*d │ %-*s │ %-*s │ %s%-*s\033[0m │ %-*s │ %*d │\n",
 			nameWidth, name,
 			portWidth, instance.Port,
 			folderWidth, webFolder,
 			dirWidth, dirListing,
 			statusColor, statusWidth, status,
-			pidWidth, pid)
+			pidWidth, pid,
+			restWidth, instance.Restarts)
 	}
 
-	fmt.Printf("└%s┴%s┴%s┴%s┴%s┴%s┘\n",
+	fmt.Printf("└%s┴%s┴%s┴%s┴%s┴%s┴%s┘\n",
 		strings.Repeat("─", nameWidth+2),
 		strings.Repeat("─", portWidth+2),
 		strings.Repeat("─", folderWidth+2),
 		strings.Repeat("─", dirWidth+2),
 		strings.Repeat("─", statusWidth+2),
-		strings.Repeat("─", pidWidth+2))
+		strings.Repeat("─", pidWidth+2),
+		strings.Repeat("─", restWidth+2))
+}
+
+func handleLogs(manager *server.Manager) {
+	logsCmd := flag.NewFlagSet("logs", flag.ExitOnError)
+	logsCmd.Usage = func() {
+		fmt.Println("Usage: nanoHttp logs <instance-name> [options]")
+		fmt.Println("\nDescription:")
+		fmt.Println("  Print the log of an instance from ~/.nanoHttp/logs/<name>/. The access")
+		fmt.Println("  log is shown when access logging is enabled, otherwise the output log.")
+		fmt.Println("\nOptions:")
+		fmt.Printf("  -f | -follow                Keep printing new lines as they are written\n")
+		fmt.Printf("  -n | -lines                 Number of lines to show, 0 for all (default 10)\n")
+		fmt.Printf("  -path                       Only show requests whose path has this prefix or matches this glob\n")
+		fmt.Printf("  -since                      Only show requests since a duration ago (e.g. 1h) or an RFC 3339 time\n")
+		fmt.Printf("  -status                     Only show requests with these statuses, e.g. 404 or 4xx,5xx\n")
+		fmt.Printf("  -stream                     Log to show: access, stdout or stderr\n")
+	}
+
+	var (
+		follow bool
+		lines  int
+		since  string
+		status string
+		path   string
+		stream string
+	)
+	logsCmd.BoolVar(&follow, "follow", false, "")
+	logsCmd.BoolVar(&follow, "f", false, "")
+	logsCmd.IntVar(&lines, "lines", 10, "")
+	logsCmd.IntVar(&lines, "n", 10, "")
+	logsCmd.StringVar(&since, "since", "", "")
+	logsCmd.StringVar(&status, "status", "", "")
+	logsCmd.StringVar(&path, "path", "", "")
+	logsCmd.StringVar(&stream, "stream", "", "")
+
+	if len(os.Args) < 3 {
+		fmt.Println("Error: instance name is required")
+		logsCmd.Usage()
+		os.Exit(1)
+	}
+
+	// Handle --help explicitly
+	if os.Args[2] == "--help" || os.Args[2] == "-h" {
+		logsCmd.Usage()
+		os.Exit(0)
+	}
+
+	name := os.Args[2]
+	if err := logsCmd.Parse(os.Args[3:]); err != nil {
+		fmt.Printf("Error parsing flags: %v\n", err)
+		os.Exit(1)
+	}
+
+	instance, err := manager.GetInstanceConfig(name)
+	if err != nil {
+		fmt.Printf("Error getting server instance: %v\n", err)
+		os.Exit(1)
+	}
+
+	if stream == "" {
+		stream = "stdout"
+		if instance.AccessLog != nil {
+			stream = "access"
+		}
+	}
+
+	var logPath string
+	switch stream {
+	case "access":
+		if instance.AccessLog == nil {
+			fmt.Printf("Error: access logging is not enabled for instance '%s'\n", name)
+			os.Exit(1)
+		}
+		logPath, err = server.AccessLogPath(name, instance.AccessLog)
+	case "stdout", "stderr":
+		var logDir string
+		if logDir, err = config.LogDir(name); err == nil {
+			logPath = filepath.Join(logDir, stream+".log")
+		}
+	default:
+		fmt.Printf("Error: invalid log stream %q (expected access, stdout or stderr)\n", stream)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error resolving log path: %v\n", err)
+		os.Exit(1)
+	}
+
+	filter := server.AccessLogFilter{Path: path}
+	if filter.Statuses, err = server.ParseStatusFilter(status); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			filter.Since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			filter.Since = t
+		} else {
+			fmt.Printf("Error: invalid -since value %q (expected a duration or an RFC 3339 time)\n", since)
+			os.Exit(1)
+		}
+	}
+	if stream != "access" && !filter.Empty() {
+		fmt.Println("Error: -since, -status and -path only apply to the access log")
+		os.Exit(1)
+	}
+
+	output, offset, err := logfile.Read(logPath, lines, filter.Match)
+	if err != nil {
+		fmt.Printf("Error reading log: %v\n", err)
+		os.Exit(1)
+	}
+	for _, line := range output {
+		fmt.Println(line)
+	}
+
+	if !follow {
+		return
+	}
+
+	stop := make(chan struct{})
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		close(stop)
+	}()
+
+	err = logfile.Follow(logPath, offset, stop, func(line string) {
+		if line != "" && filter.Match(line) {
+			fmt.Println(line)
+		}
+	})
+	if err != nil {
+		fmt.Printf("Error following log: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func handleStatus(configDir string) {
+	statusCmd := flag.NewFlagSet("status", flag.ExitOnError)
+	statusCmd.Usage = func() {
+		fmt.Println("Usage: nanoHttp status [instance-name]")
+		fmt.Println("\nDescription:")
+		fmt.Println("  Report the health of one or all instances. Instances with health")
+		fmt.Println("  endpoints are probed on their readiness path; the others are only")
+		fmt.Println("  checked for a running process. Exits with status 1 when a running")
+		fmt.Println("  instance, or the requested instance, is not healthy.")
+	}
+
+	// Handle --help explicitly
+	if len(os.Args) > 2 && (os.Args[2] == "--help" || os.Args[2] == "-h") {
+		statusCmd.Usage()
+		os.Exit(0)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	var names []string
+	if len(os.Args) > 2 {
+		if _, exists := cfg.GetInstance(os.Args[2]); !exists {
+			fmt.Printf("Error: instance %s not found\n", os.Args[2])
+			os.Exit(1)
+		}
+		names = []string{os.Args[2]}
+	} else {
+		for name := range cfg.Instances {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	if len(names) == 0 {
+		fmt.Println("No instances found")
+		return
+	}
+
+	// A running daemon knows the real state of the instances it hosts
+	if client, err := daemon.Dial(configDir); err == nil {
+		if statuses, err := client.List(); err == nil {
+			for _, status := range statuses {
+				if instance, exists := cfg.Instances[status.Name]; exists {
+					instance.IsRunning = status.Running
+					instance.PID = status.PID
+					cfg.Instances[status.Name] = instance
+				}
+			}
+		}
+	}
+
+	failed := false
+	for _, name := range names {
+		instance := cfg.Instances[name]
+
+		var state, detail string
+		healthy := false
+		if instance.Health != nil {
+			health, err := server.CheckHealth(instance)
+			switch {
+			case err != nil && !instance.IsRunning:
+				state = "stopped"
+			case err != nil:
+				state, detail = "down", err.Error()
+			case health.Status != server.HealthOK:
+				state = "unhealthy"
+				for check, result := range health.Checks {
+					if result != server.HealthOK {
+						detail = fmt.Sprintf("%s: %s", check, result)
+					}
+				}
+			default:
+				state, healthy = "healthy", true
+				detail = fmt.Sprintf("uptime %s, version %s", health.Uptime, health.Version)
+			}
+		} else if instance.IsRunning {
+			state, healthy = "running", true
+			detail = fmt.Sprintf("PID %d, no health endpoint", instance.PID)
+		} else {
+			state = "stopped"
+		}
+
+		if !healthy && (len(names) == 1 || instance.IsRunning) {
+			failed = true
+		}
+		fmt.Println(strings.TrimSpace(fmt.Sprintf("%-20s %-10s %s", name, state, detail)))
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// listFlag collects the values of a flag that may be repeated
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 // truncateString truncates a string if it's longer than maxLen and adds "..."
@@ -3,10 +3,12 @@ module github.com/mguptahub/nanoHttp
 go 1.21
 
 require (
+	github.com/andybalholm/brotli v1.1.1
 	github.com/gorilla/mux v1.8.1
 	github.com/google/go-github/v45 v45.2.0
 	github.com/google/go-querystring v1.1.0 // indirect
-	golang.org/x/crypto v0.14.0 // indirect
+	golang.org/x/crypto v0.14.0
 	golang.org/x/net v0.17.0 // indirect
-	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/sys v0.14.0
+	golang.org/x/term v0.14.0
 )
@@ -0,0 +1,342 @@
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	rootValidity = 10 * 365 * 24 * time.Hour
+	// Browsers reject leaf certificates valid for longer than 825 days
+	leafValidity = 825 * 24 * time.Hour
+	// Leaf certificates are reissued once they get this close to expiry
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// DefaultHosts are included in every leaf certificate
+var DefaultHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// Authority is the local root CA used to sign development certificates
+type Authority struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+}
+
+// Dir returns the directory holding the root CA and the issued certificates
+func Dir(configDir string) string {
+	return filepath.Join(configDir, "ca")
+}
+
+// RootCertPath returns the path of the root CA certificate
+func RootCertPath(configDir string) string {
+	return filepath.Join(Dir(configDir), "rootCA.pem")
+}
+
+func rootKeyPath(configDir string) string {
+	return filepath.Join(Dir(configDir), "rootCA-key.pem")
+}
+
+// LeafPaths returns the certificate and key paths for an instance
+func LeafPaths(configDir, name string) (string, string) {
+	dir := filepath.Join(Dir(configDir), "certs")
+	return filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+}
+
+// LoadOrCreate loads the local root CA, creating it on first use
+func LoadOrCreate(configDir string) (*Authority, error) {
+	certPEM, err := os.ReadFile(RootCertPath(configDir))
+	if os.IsNotExist(err) {
+		return create(configDir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading root CA: %v", err)
+	}
+
+	keyPEM, err := os.ReadFile(rootKeyPath(configDir))
+	if err != nil {
+		return nil, fmt.Errorf("error reading root CA key: %v", err)
+	}
+
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing root CA: %v", err)
+	}
+	key, err := parseKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing root CA key: %v", err)
+	}
+
+	return &Authority{cert: cert, key: key, certPEM: certPEM}, nil
+}
+
+// create generates a new root CA and stores it under the config directory
+func create(configDir string) (*Authority, error) {
+	if err := os.MkdirAll(Dir(configDir), 0700); err != nil {
+		return nil, fmt.Errorf("error creating CA directory: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating root CA key: %v", err)
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"nanoHttp development CA"},
+			CommonName:   fmt.Sprintf("nanoHttp local CA (%s)", hostname),
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(rootValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating root CA: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing root CA: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := writeKey(rootKeyPath(configDir), key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(RootCertPath(configDir), certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("error writing root CA: %v", err)
+	}
+
+	return &Authority{cert: cert, key: key, certPEM: certPEM}, nil
+}
+
+// CertPEM returns the PEM-encoded root certificate
+func (a *Authority) CertPEM() []byte {
+	return a.certPEM
+}
+
+// Issue signs a new leaf certificate for the given hosts and writes it to
+// the given paths
+func (a *Authority) Issue(certFile, keyFile string, hosts []string) error {
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return fmt.Errorf("error creating certificate directory: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error generating certificate key: %v", err)
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"nanoHttp development certificate"},
+			CommonName:   hosts[0],
+		},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(leafValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, key.Public(), a.key)
+	if err != nil {
+		return fmt.Errorf("error signing certificate: %v", err)
+	}
+
+	// Serve the chain so clients only need to trust the root
+	var chain bytes.Buffer
+	pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	chain.Write(a.certPEM)
+
+	if err := writeKey(keyFile, key); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, chain.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing certificate: %v", err)
+	}
+	return nil
+}
+
+// EnsureLeaf makes sure an instance has a certificate signed by the local CA
+// that covers the default and given hosts and is not close to expiry,
+// issuing a new one when needed. It returns the certificate and key paths.
+func EnsureLeaf(configDir, name string, hosts []string) (string, string, error) {
+	certFile, keyFile := LeafPaths(configDir, name)
+	hosts = leafHosts(hosts)
+
+	authority, err := LoadOrCreate(configDir)
+	if err != nil {
+		return "", "", err
+	}
+
+	if leafIsCurrent(authority, certFile, keyFile, hosts) {
+		return certFile, keyFile, nil
+	}
+
+	if err := authority.Issue(certFile, keyFile, hosts); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+// RemoveLeaf deletes the certificate issued for an instance
+func RemoveLeaf(configDir, name string) error {
+	certFile, keyFile := LeafPaths(configDir, name)
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// leafIsCurrent reports whether an existing leaf certificate can be reused
+func leafIsCurrent(authority *Authority, certFile, keyFile string, hosts []string) bool {
+	if _, err := os.Stat(keyFile); err != nil {
+		return false
+	}
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return false
+	}
+	cert, err := parseCert(data)
+	if err != nil {
+		return false
+	}
+
+	if time.Until(cert.NotAfter) < renewBefore {
+		return false
+	}
+	if cert.CheckSignatureFrom(authority.cert) != nil {
+		return false
+	}
+	for _, host := range hosts {
+		if !certNames(cert, host) {
+			return false
+		}
+	}
+	return true
+}
+
+// certNames reports whether a certificate lists a host among its names. The
+// names are compared as they are, so a wildcard host such as *.example.test
+// is found too, which VerifyHostname would treat as a name to match.
+func certNames(cert *x509.Certificate, host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		for _, certIP := range cert.IPAddresses {
+			if certIP.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, name := range cert.DNSNames {
+		if strings.EqualFold(name, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// leafHosts merges the default hosts with the configured ones, keeping the
+// defaults first and dropping duplicates
+func leafHosts(hosts []string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, host := range DefaultHosts {
+		seen[host] = true
+		merged = append(merged, host)
+	}
+
+	extra := append([]string(nil), hosts...)
+	sort.Strings(extra)
+	for _, host := range extra {
+		if host != "" && !seen[host] {
+			seen[host] = true
+			merged = append(merged, host)
+		}
+	}
+	return merged
+}
+
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("error generating serial number: %v", err)
+	}
+	return serial, nil
+}
+
+func writeKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("error encoding private key: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("error writing private key: %v", err)
+	}
+	return nil
+}
+
+func parseCert(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type")
+	}
+	return signer, nil
+}
@@ -0,0 +1,81 @@
+package ca
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestEnsureLeafReusesCertificate(t *testing.T) {
+	configDir := t.TempDir()
+	hosts := []string{"*.example.test", "example.test", "192.168.1.10"}
+
+	certFile, _, err := EnsureLeaf(configDir, "site", hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := parseCert(issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range leafHosts(hosts) {
+		if !certNames(cert, host) {
+			t.Errorf("certificate doesn't name %s", host)
+		}
+	}
+
+	// The same hosts, in any order or case, keep the certificate
+	if _, _, err := EnsureLeaf(configDir, "site", []string{"192.168.1.10", "EXAMPLE.test", "*.example.test"}); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := os.ReadFile(certFile); !bytes.Equal(current, issued) {
+		t.Error("certificate was reissued for the same hosts")
+	}
+
+	// A host it doesn't name gets a new one
+	if _, _, err := EnsureLeaf(configDir, "site", append(hosts, "*.other.test")); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := os.ReadFile(certFile); bytes.Equal(current, issued) {
+		t.Error("certificate wasn't reissued for a new host")
+	}
+}
+
+func TestCertNames(t *testing.T) {
+	configDir := t.TempDir()
+	certFile, _, err := EnsureLeaf(configDir, "site", []string{"*.example.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := parseCert(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host  string
+		names bool
+	}{
+		{"*.example.test", true},
+		{"localhost", true},
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"0:0:0:0:0:0:0:1", true},
+		{"www.example.test", false},
+		{"example.test", false},
+		{"127.0.0.2", false},
+	}
+	for _, tt := range tests {
+		if got := certNames(cert, tt.host); got != tt.names {
+			t.Errorf("certNames(%s) = %v, want %v", tt.host, got, tt.names)
+		}
+	}
+}
@@ -2,18 +2,288 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
+)
+
+// Restart policies for supervised instances
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
 )
 
 // InstanceConfig represents the configuration for a single HTTP server instance
 type InstanceConfig struct {
-	Name            string `json:"name"`
-	Port            int    `json:"port"`
-	WebFolder       string `json:"web_folder"`
-	AllowDirListing bool   `json:"allow_dir_listing"`
-	IsRunning       bool   `json:"is_running"`
-	PID             int    `json:"pid,omitempty"`
+	Name            string             `json:"name"`
+	Port            int                `json:"port"`
+	Hosts           []string           `json:"hosts,omitempty"`
+	DefaultHost     bool               `json:"default_host,omitempty"`
+	WebFolder       string             `json:"web_folder"`
+	AllowDirListing bool               `json:"allow_dir_listing"`
+	SPA             bool               `json:"spa,omitempty"`
+	SPAFallback     string             `json:"spa_fallback,omitempty"`
+	Mounts          []Mount            `json:"mounts,omitempty"`
+	Rules           []RewriteRule      `json:"rules,omitempty"`
+	ErrorPages      map[int]string     `json:"error_pages,omitempty"`
+	Compression     *CompressionConfig `json:"compression,omitempty"`
+	AccessLog       *AccessLogConfig   `json:"access_log,omitempty"`
+	Logs            *LogConfig         `json:"logs,omitempty"`
+	Metrics         *MetricsConfig     `json:"metrics,omitempty"`
+	Health          *HealthConfig      `json:"health,omitempty"`
+	Proxies         []ProxyRoute       `json:"proxies,omitempty"`
+	IPFilter        *IPFilterConfig    `json:"ip_filter,omitempty"`
+	RateLimit       *RateLimitConfig   `json:"rate_limit,omitempty"`
+	BasicAuth       *BasicAuthConfig   `json:"basic_auth,omitempty"`
+	TokenAuth       *TokenAuthConfig   `json:"token_auth,omitempty"`
+	SignedURLs      *SignedURLConfig   `json:"signed_urls,omitempty"`
+	TLS             *TLSConfig         `json:"tls,omitempty"`
+	Restart         *RestartPolicy     `json:"restart,omitempty"`
+	IsRunning       bool               `json:"is_running"`
+	PID             int                `json:"pid,omitempty"`
+	Restarts        int                `json:"restarts,omitempty"`
+	LastExit        string             `json:"last_exit,omitempty"`
+}
+
+// DefaultSPAFallback is served for unknown paths when SPA mode is enabled
+// without an explicit fallback file
+const DefaultSPAFallback = "index.html"
+
+// SPAFallbackFile returns the file served for unknown paths in SPA mode,
+// relative to the web folder, or an empty string when SPA mode is disabled
+func (c InstanceConfig) SPAFallbackFile() string {
+	if !c.SPA {
+		return ""
+	}
+	if c.SPAFallback == "" {
+		return DefaultSPAFallback
+	}
+	return c.SPAFallback
+}
+
+// Mount serves a folder at a URL prefix alongside the web folder, with its
+// own directory listing and SPA settings
+type Mount struct {
+	Prefix          string `json:"prefix"`
+	Folder          string `json:"folder"`
+	AllowDirListing bool   `json:"allow_dir_listing,omitempty"`
+	SPA             bool   `json:"spa,omitempty"`
+	SPAFallback     string `json:"spa_fallback,omitempty"`
+}
+
+// SPAFallbackFile returns the file served for unknown paths below the mount,
+// relative to its folder, or an empty string when SPA mode is disabled
+func (m Mount) SPAFallbackFile() string {
+	if !m.SPA {
+		return ""
+	}
+	if m.SPAFallback == "" {
+		return DefaultSPAFallback
+	}
+	return m.SPAFallback
+}
+
+// RewriteRule rewrites or redirects requests whose path matches the regular
+// expression Match. Target may refer to its captures as $1 or ${name}. A zero
+// Status rewrites the request internally; a 3xx status redirects it. The
+// first matching rule of an instance applies.
+type RewriteRule struct {
+	Match   string            `json:"match"`
+	Target  string            `json:"target"`
+	Status  int               `json:"status,omitempty"`
+	Methods []string          `json:"methods,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// VirtualHost reports whether the instance is selected by the Host header, in
+// which case it can share its port with other virtual hosts
+func (c InstanceConfig) VirtualHost() bool {
+	return len(c.Hosts) > 0 || c.DefaultHost
+}
+
+// CompressionConfig enables on-the-fly response compression. Empty fields
+// fall back to the server defaults.
+type CompressionConfig struct {
+	Encodings []string `json:"encodings,omitempty"`
+	MinSize   int      `json:"min_size,omitempty"`
+	MimeTypes []string `json:"mime_types,omitempty"`
+}
+
+// AccessLogConfig enables request logging for an instance. The log is
+// written to the instance's log directory unless File is set.
+type AccessLogConfig struct {
+	Format string `json:"format,omitempty"`
+	File   string `json:"file,omitempty"`
+}
+
+// LogConfig controls rotation and retention of an instance's log files.
+// Empty fields fall back to the defaults.
+type LogConfig struct {
+	MaxSizeMB int      `json:"max_size_mb,omitempty"`
+	MaxAge    Duration `json:"max_age,omitempty"`
+	MaxFiles  int      `json:"max_files,omitempty"`
+	Compress  bool     `json:"compress,omitempty"`
+}
+
+// MetricsConfig enables the Prometheus metrics endpoint of an instance. It is
+// served on the instance's own port unless Port is set.
+type MetricsConfig struct {
+	Path string `json:"path,omitempty"`
+	Port int    `json:"port,omitempty"`
+}
+
+// HealthConfig enables the liveness and readiness endpoints of an instance.
+// Empty paths fall back to /healthz and /readyz.
+type HealthConfig struct {
+	LivenessPath  string `json:"liveness_path,omitempty"`
+	ReadinessPath string `json:"readiness_path,omitempty"`
+}
+
+// ProxyRoute forwards requests under a path prefix to an upstream server, or
+// balances them across a pool of upstreams. Header values replace the
+// forwarded headers; an empty value removes one.
+type ProxyRoute struct {
+	Prefix          string               `json:"prefix"`
+	Upstream        string               `json:"upstream,omitempty"`
+	Upstreams       []string             `json:"upstreams,omitempty"`
+	Strategy        string               `json:"strategy,omitempty"`
+	Retries         int                  `json:"retries,omitempty"`
+	HealthCheck     *UpstreamHealthCheck `json:"health_check,omitempty"`
+	StripPrefix     bool                 `json:"strip_prefix,omitempty"`
+	PreserveHost    bool                 `json:"preserve_host,omitempty"`
+	RequestHeaders  map[string]string    `json:"request_headers,omitempty"`
+	ResponseHeaders map[string]string    `json:"response_headers,omitempty"`
+}
+
+// Targets returns every upstream URL of the route
+func (r ProxyRoute) Targets() []string {
+	if r.Upstream == "" {
+		return r.Upstreams
+	}
+	return append([]string{r.Upstream}, r.Upstreams...)
+}
+
+// UpstreamHealthCheck actively probes the upstreams of a proxy route. Empty
+// fields fall back to the server defaults.
+type UpstreamHealthCheck struct {
+	Path     string   `json:"path,omitempty"`
+	Interval Duration `json:"interval,omitempty"`
+	Timeout  Duration `json:"timeout,omitempty"`
+}
+
+// IPFilterConfig limits the clients an instance serves to the IP addresses
+// and CIDR blocks of Allow, when set, minus those of Deny. Behind one of the
+// TrustedProxies, the client is taken from X-Forwarded-For.
+type IPFilterConfig struct {
+	Allow          []string `json:"allow,omitempty"`
+	Deny           []string `json:"deny,omitempty"`
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+}
+
+// RateLimitConfig throttles the clients of an instance. Each client may send
+// Rate requests per second on average, in bursts of up to Burst. By is "ip" to
+// tell clients apart by address, or "user" to use the user or token they
+// authenticated with, falling back to the address. MaxRequests caps the
+// requests the instance serves at once. Zero fields are not limited, except
+// Burst, which falls back to Rate rounded up.
+type RateLimitConfig struct {
+	Rate        float64 `json:"rate,omitempty"`
+	Burst       int     `json:"burst,omitempty"`
+	By          string  `json:"by,omitempty"`
+	MaxRequests int     `json:"max_requests,omitempty"`
+}
+
+// BasicAuthConfig protects an instance with HTTP Basic authentication
+// against the users of an htpasswd file. The whole site is protected unless
+// Paths lists the prefixes that are.
+type BasicAuthConfig struct {
+	File  string   `json:"file"`
+	Realm string   `json:"realm,omitempty"`
+	Paths []string `json:"paths,omitempty"`
+}
+
+// TokenAuthConfig lets automated clients into an instance with bearer tokens
+// or API keys. The whole site is protected unless Paths lists the prefixes
+// that are.
+type TokenAuthConfig struct {
+	Paths  []string      `json:"paths,omitempty"`
+	Tokens []AccessToken `json:"tokens,omitempty"`
+}
+
+// AccessToken is a token issued for an instance. Only a hash of the token is
+// stored. It grants the whole site unless Paths lists the prefixes it may
+// access, and never expires when Expires is nil.
+type AccessToken struct {
+	Name    string     `json:"name"`
+	Hash    string     `json:"hash"`
+	Paths   []string   `json:"paths,omitempty"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Expired reports whether the token has expired at the given time
+func (t AccessToken) Expired(now time.Time) bool {
+	return t.Expires != nil && !now.Before(*t.Expires)
+}
+
+// SignedURLConfig lets anyone holding a URL signed with the instance's secret
+// download that path until the URL expires. The whole site requires a signed
+// URL or other credentials unless Paths lists the prefixes that do.
+type SignedURLConfig struct {
+	Secret string   `json:"secret"`
+	Paths  []string `json:"paths,omitempty"`
+}
+
+// TLSConfig enables HTTPS for an instance
+type TLSConfig struct {
+	CertFile     string   `json:"cert_file"`
+	KeyFile      string   `json:"key_file"`
+	Auto         bool     `json:"auto,omitempty"`
+	Hosts        []string `json:"hosts,omitempty"`
+	MinVersion   string   `json:"min_version,omitempty"`
+	CipherSuites []string `json:"cipher_suites,omitempty"`
+	RedirectPort int      `json:"redirect_port,omitempty"`
+}
+
+// RestartPolicy controls how a crashed instance is restarted
+type RestartPolicy struct {
+	Policy      string   `json:"policy"`
+	MaxRestarts int      `json:"max_restarts,omitempty"`
+	Backoff     Duration `json:"backoff,omitempty"`
+	MaxBackoff  Duration `json:"max_backoff,omitempty"`
+}
+
+// Duration is a time.Duration stored in the config file as a human-readable
+// string such as "1m30s"
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes a duration from a string or a number of nanoseconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(v)
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration: %s", string(data))
+	}
+	return nil
 }
 
 // Config represents the main configuration file structure
@@ -41,6 +311,20 @@ func GetConfigDir() (string, error) {
 	return configDir, nil
 }
 
+// LogDir returns the directory holding the logs of an instance, creating it
+// if needed
+func LogDir(name string) (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	logDir := filepath.Join(configDir, "logs", name)
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return "", err
+	}
+	return logDir, nil
+}
+
 // GetConfigPath returns the path to the configuration file
 func GetConfigPath() (string, error) {
 	configDir, err := GetConfigDir()
@@ -117,3 +401,45 @@ func (c *Config) GetInstance(name string) (InstanceConfig, bool) {
 	instance, exists := c.Instances[name]
 	return instance, exists
 }
+
+// SetInstanceStatus records whether an instance is running and which process hosts it
+func SetInstanceStatus(name string, running bool, pid int) error {
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	instance, exists := config.Instances[name]
+	if !exists {
+		return fmt.Errorf("instance %s not found", name)
+	}
+
+	instance.IsRunning = running
+	instance.PID = pid
+	if !running {
+		instance.PID = 0
+	}
+	config.Instances[name] = instance
+	return SaveConfig(config)
+}
+
+// RecordExit records how many times an instance has been restarted and why it
+// last exited. An empty reason keeps the previously recorded one.
+func RecordExit(name string, restarts int, reason string) error {
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	instance, exists := config.Instances[name]
+	if !exists {
+		return fmt.Errorf("instance %s not found", name)
+	}
+
+	instance.Restarts = restarts
+	if reason != "" {
+		instance.LastExit = reason
+	}
+	config.Instances[name] = instance
+	return SaveConfig(config)
+}
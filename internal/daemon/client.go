@@ -0,0 +1,117 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/server"
+)
+
+// Client talks to a running daemon over its control socket
+type Client struct {
+	httpClient *http.Client
+}
+
+// Dial connects to the daemon's control socket, returning an error when no
+// daemon is listening
+func Dial(configDir string) (*Client, error) {
+	socketPath := SocketPath(configDir)
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("daemon is not running: %v", err)
+	}
+	conn.Close()
+
+	return &Client{
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}, nil
+}
+
+// List returns the status of every configured instance
+func (c *Client) List() ([]InstanceStatus, error) {
+	var statuses []InstanceStatus
+	err := c.do(http.MethodGet, "/v1/instances", &statuses)
+	return statuses, err
+}
+
+// Status returns the status of a single instance
+func (c *Client) Status(name string) (*InstanceStatus, error) {
+	var status InstanceStatus
+	if err := c.do(http.MethodGet, "/v1/instances/"+url.PathEscape(name), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Stats returns the request counters of a running instance
+func (c *Client) Stats(name string) (*server.Stats, error) {
+	var stats server.Stats
+	if err := c.do(http.MethodGet, "/v1/instances/"+url.PathEscape(name)+"/stats", &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Start asks the daemon to start an instance
+func (c *Client) Start(name string) (*InstanceStatus, error) {
+	var status InstanceStatus
+	if err := c.do(http.MethodPost, "/v1/instances/"+url.PathEscape(name)+"/start", &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Stop asks the daemon to stop an instance
+func (c *Client) Stop(name string) (*InstanceStatus, error) {
+	var status InstanceStatus
+	if err := c.do(http.MethodPost, "/v1/instances/"+url.PathEscape(name)+"/stop", &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Reload asks the daemon to reconcile its instances with the config file
+func (c *Client) Reload() ([]InstanceStatus, error) {
+	var statuses []InstanceStatus
+	err := c.do(http.MethodPost, "/v1/reload", &statuses)
+	return statuses, err
+}
+
+func (c *Client) do(method, path string, out interface{}) error {
+	req, err := http.NewRequest(method, "http://nanoHttp"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error contacting daemon: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+			return fmt.Errorf("daemon returned status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("%s", errResp.Error)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding daemon response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+	"github.com/mguptahub/nanoHttp/internal/server"
+)
+
+// InstanceStatus describes an instance as seen by the daemon
+type InstanceStatus struct {
+	Name      string        `json:"name"`
+	Port      int           `json:"port"`
+	WebFolder string        `json:"web_folder"`
+	Running   bool          `json:"running"`
+	PID       int           `json:"pid,omitempty"`
+	Stats     *server.Stats `json:"stats,omitempty"`
+}
+
+// errorResponse is the body returned by the control API on failure
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// controlHandler serves the JSON control API:
+//
+//	GET  /v1/instances               list all configured instances
+//	GET  /v1/instances/<name>        status of one instance
+//	GET  /v1/instances/<name>/stats  request counters of a running instance
+//	POST /v1/instances/<name>/start  start an instance
+//	POST /v1/instances/<name>/stop   stop an instance
+//	POST /v1/reload                  reconcile instances with the config file
+func (d *Daemon) controlHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/v1/instances", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+
+		statuses, err := d.statuses()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, statuses)
+	})
+
+	mux.HandleFunc("/v1/instances/", func(w http.ResponseWriter, r *http.Request) {
+		name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/instances/"), "/")
+
+		switch {
+		case r.Method == http.MethodGet && action == "":
+			status, err := d.status(name)
+			if err != nil {
+				writeError(w, http.StatusNotFound, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, status)
+
+		case r.Method == http.MethodGet && action == "stats":
+			srv, hosted := d.instances.GetInstance(name)
+			if !hosted {
+				writeError(w, http.StatusNotFound, fmt.Errorf("server %s is not running", name))
+				return
+			}
+			writeJSON(w, http.StatusOK, srv.Stats())
+
+		case r.Method == http.MethodPost && action == "start":
+			if err := d.StartInstance(name); err != nil {
+				writeError(w, http.StatusConflict, err)
+				return
+			}
+			d.writeStatus(w, name)
+
+		case r.Method == http.MethodPost && action == "stop":
+			if err := d.StopInstance(name); err != nil {
+				writeError(w, http.StatusConflict, err)
+				return
+			}
+			d.writeStatus(w, name)
+
+		default:
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown request %s %s", r.Method, r.URL.Path))
+		}
+	})
+
+	mux.HandleFunc("/v1/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+
+		if err := d.Reload(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		statuses, err := d.statuses()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, statuses)
+	})
+
+	return mux
+}
+
+// statuses returns the status of every configured instance, sorted by name
+func (d *Daemon) statuses() ([]InstanceStatus, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %v", err)
+	}
+
+	statuses := make([]InstanceStatus, 0, len(cfg.Instances))
+	for name, instance := range cfg.Instances {
+		statuses = append(statuses, d.instanceStatus(name, instance))
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses, nil
+}
+
+// status returns the status of a single configured instance
+func (d *Daemon) status(name string) (InstanceStatus, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return InstanceStatus{}, fmt.Errorf("error loading config: %v", err)
+	}
+
+	instance, exists := cfg.GetInstance(name)
+	if !exists {
+		return InstanceStatus{}, fmt.Errorf("instance %s not found", name)
+	}
+	return d.instanceStatus(name, instance), nil
+}
+
+func (d *Daemon) instanceStatus(name string, instance config.InstanceConfig) InstanceStatus {
+	status := InstanceStatus{
+		Name:      name,
+		Port:      instance.Port,
+		WebFolder: instance.WebFolder,
+	}
+
+	if srv, hosted := d.instances.GetInstance(name); hosted {
+		stats := srv.Stats()
+		status.Running = true
+		status.PID = instance.PID
+		status.Stats = &stats
+	}
+	return status
+}
+
+func (d *Daemon) writeStatus(w http.ResponseWriter, name string) {
+	status, err := d.status(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorResponse{Error: err.Error()})
+}
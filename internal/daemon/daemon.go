@@ -0,0 +1,392 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+	"github.com/mguptahub/nanoHttp/internal/instance"
+	"github.com/mguptahub/nanoHttp/internal/server"
+	"github.com/mguptahub/nanoHttp/internal/supervisor"
+)
+
+// Daemon hosts every configured instance inside a single long-lived process
+type Daemon struct {
+	configDir   string
+	instances   *instance.Manager
+	supervisors map[string]chan struct{}
+	mu          sync.Mutex
+}
+
+// New creates a new daemon using the given configuration directory
+func New(configDir string) *Daemon {
+	return &Daemon{
+		configDir:   configDir,
+		instances:   instance.NewManager(),
+		supervisors: make(map[string]chan struct{}),
+	}
+}
+
+// PIDFilePath returns the path of the daemon's PID file
+func PIDFilePath(configDir string) string {
+	return filepath.Join(configDir, "daemon.pid")
+}
+
+// SocketPath returns the path of the daemon's control socket
+func SocketPath(configDir string) string {
+	return filepath.Join(configDir, "nanoHttp.sock")
+}
+
+// IsRunning reports whether a daemon is running and returns its PID
+func IsRunning(configDir string) (int, bool) {
+	data, err := os.ReadFile(PIDFilePath(configDir))
+	if err != nil {
+		return 0, false
+	}
+
+	var pid int
+	if _, err := fmt.Sscanf(string(data), "%d", &pid); err != nil {
+		return 0, false
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, false
+	}
+
+	// On Unix-like systems, FindProcess always succeeds, so we need to check if the process is actually running
+	if err := process.Signal(syscall.Signal(0)); err != nil {
+		return 0, false
+	}
+	return pid, true
+}
+
+// Stop asks a running daemon to gracefully stop all instances and exit
+func Stop(configDir string) error {
+	pid, running := IsRunning(configDir)
+	if !running {
+		return fmt.Errorf("daemon is not running")
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("error finding daemon process: %v", err)
+	}
+
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		return fmt.Errorf("error signaling daemon: %v", err)
+	}
+	return nil
+}
+
+// Run starts the instances marked as running, serves the control API and
+// blocks until the daemon receives SIGINT or SIGTERM. SIGHUP reloads the
+// configuration.
+func (d *Daemon) Run() error {
+	if pid, running := IsRunning(d.configDir); running {
+		return fmt.Errorf("daemon is already running (PID %d)", pid)
+	}
+
+	if err := os.WriteFile(PIDFilePath(d.configDir), []byte(fmt.Sprintf("%d", os.Getpid())), 0644); err != nil {
+		return fmt.Errorf("error writing PID file: %v", err)
+	}
+	defer os.Remove(PIDFilePath(d.configDir))
+
+	// A previous daemon that was killed may have left its socket behind
+	socketPath := SocketPath(d.configDir)
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("error creating control socket: %v", err)
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("error securing control socket: %v", err)
+	}
+
+	controlServer := &http.Server{Handler: d.controlHandler()}
+	go func() {
+		if err := controlServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("Error serving control socket: %v\n", err)
+		}
+	}()
+	defer controlServer.Shutdown(context.Background())
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+
+	if err := d.startAll(); err != nil {
+		return err
+	}
+
+	for sig := range sigChan {
+		if sig == syscall.SIGHUP {
+			fmt.Println("Reloading configuration")
+			if err := d.Reload(); err != nil {
+				fmt.Printf("Error reloading configuration: %v\n", err)
+			}
+			continue
+		}
+		break
+	}
+
+	fmt.Println("Shutting down daemon...")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, name := range d.instances.ListInstances() {
+		if err := d.stopInstance(name); err != nil {
+			fmt.Printf("Error stopping instance '%s': %v\n", name, err)
+		}
+	}
+	return nil
+}
+
+// startAll starts the instances marked as running, such as those a previous
+// daemon hosted when it was killed. Instances still served by a process of
+// their own are left to it.
+func (d *Daemon) startAll() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("error loading config: %v", err)
+	}
+
+	for _, instance := range cfg.Instances {
+		if !instance.IsRunning {
+			continue
+		}
+		if pid, ok := servedElsewhere(instance); ok {
+			fmt.Printf("Instance '%s' is already running in process %d, leaving it there\n", instance.Name, pid)
+			continue
+		}
+		if err := d.startInstance(instance); err != nil {
+			fmt.Printf("Error starting instance '%s': %v\n", instance.Name, err)
+		}
+	}
+	return nil
+}
+
+// StartInstance starts hosting a configured instance
+func (d *Daemon) StartInstance(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, hosted := d.instances.GetInstance(name); hosted {
+		return fmt.Errorf("server %s is already running", name)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("error loading config: %v", err)
+	}
+
+	instance, exists := cfg.GetInstance(name)
+	if !exists {
+		return fmt.Errorf("instance %s not found", name)
+	}
+	if pid, ok := servedElsewhere(instance); ok {
+		return fmt.Errorf("instance %s is already running in process %d", name, pid)
+	}
+	return d.startInstance(instance)
+}
+
+// StopInstance stops a hosted instance
+func (d *Daemon) StopInstance(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, hosted := d.instances.GetInstance(name); !hosted {
+		return fmt.Errorf("server %s is not running", name)
+	}
+	return d.stopInstance(name)
+}
+
+// Reload reconciles the hosted instances with the configuration on disk:
+// instances marked as running are started, instances marked as stopped or
+// removed are stopped, and instances whose settings changed are restarted
+func (d *Daemon) Reload() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("error loading config: %v", err)
+	}
+
+	for _, name := range d.instances.ListInstances() {
+		instance, exists := cfg.Instances[name]
+		if !exists || !instance.IsRunning {
+			if err := d.stopInstance(name); err != nil {
+				fmt.Printf("Error stopping instance '%s': %v\n", name, err)
+			}
+			continue
+		}
+
+		if srv, ok := d.instances.GetInstance(name); ok && !sameSettings(srv.GetConfig(), instance) {
+			fmt.Printf("Configuration of instance '%s' changed, restarting\n", name)
+			if err := d.stopInstance(name); err != nil {
+				fmt.Printf("Error stopping instance '%s': %v\n", name, err)
+			}
+		}
+	}
+
+	for name, instance := range cfg.Instances {
+		if !instance.IsRunning {
+			continue
+		}
+		if _, ok := servedElsewhere(instance); ok {
+			continue
+		}
+		if _, hosted := d.instances.GetInstance(name); !hosted {
+			if err := d.startInstance(instance); err != nil {
+				fmt.Printf("Error starting instance '%s': %v\n", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Daemon) startInstance(instance config.InstanceConfig) error {
+	if err := d.instances.StartInstance(instance); err != nil {
+		if err := config.SetInstanceStatus(instance.Name, false, 0); err != nil {
+			fmt.Printf("Error updating config: %v\n", err)
+		}
+		return err
+	}
+
+	fmt.Printf("Instance '%s' serving on port %d\n", instance.Name, instance.Port)
+	if err := config.SetInstanceStatus(instance.Name, true, os.Getpid()); err != nil {
+		return fmt.Errorf("error updating config: %v", err)
+	}
+	// A manual start begins a fresh restart budget
+	if err := config.RecordExit(instance.Name, 0, ""); err != nil {
+		return fmt.Errorf("error updating config: %v", err)
+	}
+
+	srv, _ := d.instances.GetInstance(instance.Name)
+	stop := make(chan struct{})
+	d.supervisors[instance.Name] = stop
+	go d.supervise(instance, srv, stop)
+	return nil
+}
+
+func (d *Daemon) stopInstance(name string) error {
+	// Tell the supervisor the exit is intentional before shutting down
+	if stop, ok := d.supervisors[name]; ok {
+		close(stop)
+		delete(d.supervisors, name)
+	}
+
+	stopErr := d.instances.StopInstance(name)
+	fmt.Printf("Instance '%s' stopped\n", name)
+
+	// The instance may have been deleted from the config, in which case there
+	// is no status left to update
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("error loading config: %v", err)
+	}
+	if _, exists := cfg.GetInstance(name); exists {
+		if err := config.SetInstanceStatus(name, false, 0); err != nil {
+			return fmt.Errorf("error updating config: %v", err)
+		}
+	}
+	return stopErr
+}
+
+// supervise waits for a hosted instance to exit and restarts it according to
+// its restart policy, until the daemon stops it on purpose
+func (d *Daemon) supervise(instance config.InstanceConfig, srv *server.Server, stop chan struct{}) {
+	name := instance.Name
+	current := srv
+
+	run := func() error {
+		if current == nil {
+			d.mu.Lock()
+			select {
+			case <-stop:
+				d.mu.Unlock()
+				return nil
+			default:
+			}
+			err := d.instances.StartInstance(instance)
+			current, _ = d.instances.GetInstance(name)
+			d.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Instance '%s' restarted on port %d\n", name, instance.Port)
+		}
+
+		err := <-current.Done()
+		current = nil
+
+		// Forget the exited server so it can be started again
+		d.mu.Lock()
+		select {
+		case <-stop:
+		default:
+			d.instances.StopInstance(name)
+		}
+		d.mu.Unlock()
+		return err
+	}
+
+	onExit := func(restarts int, err error, restarting bool) {
+		fmt.Printf("Instance '%s' exited: %s\n", name, supervisor.ExitReason(err))
+		if err := config.RecordExit(name, restarts, supervisor.ExitReason(err)); err != nil {
+			fmt.Printf("Error updating config: %v\n", err)
+		}
+		if restarting {
+			return
+		}
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.supervisors[name] == stop {
+			delete(d.supervisors, name)
+		}
+		if err := config.SetInstanceStatus(name, false, 0); err != nil {
+			fmt.Printf("Error updating config: %v\n", err)
+		}
+	}
+
+	supervisor.Run(supervisor.NewPolicy(instance.Restart), stop, run, onExit)
+}
+
+// servedElsewhere returns the PID of another live process that serves an
+// instance, such as one started before the daemon
+func servedElsewhere(instance config.InstanceConfig) (int, bool) {
+	if !instance.IsRunning || instance.PID == 0 || instance.PID == os.Getpid() {
+		return 0, false
+	}
+	process, err := os.FindProcess(instance.PID)
+	if err != nil {
+		return 0, false
+	}
+	if err := process.Signal(syscall.Signal(0)); err != nil {
+		return 0, false
+	}
+	return instance.PID, true
+}
+
+// sameSettings reports whether two instance configurations differ only in
+// their runtime status
+func sameSettings(a, b config.InstanceConfig) bool {
+	for _, c := range []*config.InstanceConfig{&a, &b} {
+		c.IsRunning, c.PID = false, 0
+		c.Restarts, c.LastExit = 0, ""
+	}
+	return reflect.DeepEqual(a, b)
+}
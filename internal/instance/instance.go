@@ -1,14 +1,20 @@
 package instance
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/mguptahub/nanoHttp/internal/config"
 	"github.com/mguptahub/nanoHttp/internal/server"
 )
 
-// Manager handles multiple server instances
+// shutdownTimeout bounds how long a stopping instance may spend draining
+// in-flight requests
+const shutdownTimeout = 10 * time.Second
+
+// Manager handles multiple server instances hosted inside the current process
 type Manager struct {
 	servers map[string]*server.Server
 	mu      sync.RWMutex
@@ -21,7 +27,7 @@ func NewManager() *Manager {
 	}
 }
 
-// StartInstance starts a server instance
+// StartInstance starts serving an instance inside the current process
 func (m *Manager) StartInstance(cfg config.InstanceConfig) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -31,7 +37,7 @@ func (m *Manager) StartInstance(cfg config.InstanceConfig) error {
 	}
 
 	srv := server.NewServer(cfg)
-	if err := srv.Start(); err != nil {
+	if err := srv.Serve(); err != nil {
 		return fmt.Errorf("failed to start instance %s: %v", cfg.Name, err)
 	}
 
@@ -39,7 +45,7 @@ func (m *Manager) StartInstance(cfg config.InstanceConfig) error {
 	return nil
 }
 
-// StopInstance stops a server instance
+// StopInstance gracefully stops an instance, waiting for in-flight requests
 func (m *Manager) StopInstance(name string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -49,11 +55,15 @@ func (m *Manager) StopInstance(name string) error {
 		return fmt.Errorf("instance %s does not exist", name)
 	}
 
-	if err := srv.Stop(); err != nil {
-		return fmt.Errorf("failed to stop instance %s: %v", name, err)
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
+	// Forget the instance even if shutdown fails, so it can be started again
 	delete(m.servers, name)
+
+	if err := srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to stop instance %s: %v", name, err)
+	}
 	return nil
 }
 
@@ -0,0 +1,482 @@
+package logfile
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+	"golang.org/x/sys/unix"
+)
+
+// Defaults used when an instance has no log settings
+const (
+	DefaultMaxSizeMB = 10
+	DefaultMaxFiles  = 5
+)
+
+// outputCheckInterval is how often the files that stdout and stderr are
+// redirected to are checked for rotation
+const outputCheckInterval = 10 * time.Second
+
+// rotatedTimeFormat is appended to the name of a rotated file. It sorts
+// lexically in chronological order.
+const rotatedTimeFormat = "20060102-150405.000"
+
+// Options controls when a log file is rotated and how many rotated files are
+// kept
+type Options struct {
+	MaxSize  int64
+	MaxAge   time.Duration
+	MaxFiles int
+	Compress bool
+}
+
+// OptionsFrom returns the rotation options of an instance, filling in the
+// defaults for unset fields
+func OptionsFrom(cfg *config.LogConfig) Options {
+	opts := Options{
+		MaxSize:  DefaultMaxSizeMB << 20,
+		MaxFiles: DefaultMaxFiles,
+	}
+	if cfg == nil {
+		return opts
+	}
+	if cfg.MaxSizeMB > 0 {
+		opts.MaxSize = int64(cfg.MaxSizeMB) << 20
+	}
+	if cfg.MaxFiles > 0 {
+		opts.MaxFiles = cfg.MaxFiles
+	}
+	opts.MaxAge = time.Duration(cfg.MaxAge)
+	opts.Compress = cfg.Compress
+	return opts
+}
+
+// Writer is a log file that rotates itself once it grows past the maximum
+// size or age. Rotated files are renamed with a timestamp suffix, optionally
+// gzipped, and pruned down to the retention count.
+type Writer struct {
+	path     string
+	opts     Options
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	fd       int // descriptor of the process kept pointing at the file, if not 0
+	mill     sync.Mutex
+	pending  sync.WaitGroup
+}
+
+// Open opens a log file for appending, creating its directory if needed
+func Open(path string, opts Options) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating log directory: %v", err)
+	}
+	w := &Writer{path: path, opts: opts}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open opens the current log file, pointing the redirected descriptor at it
+// if there is one. The age of an existing file is measured
+// from its last modification, since its creation time is not portable.
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+
+	if w.fd != 0 {
+		if err := unix.Dup2(int(file.Fd()), w.fd); err != nil {
+			file.Close()
+			return fmt.Errorf("error redirecting output to log file: %v", err)
+		}
+	}
+
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = time.Now()
+	if w.size > 0 {
+		w.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+// Write appends to the log, rotating it first when the write would exceed
+// the maximum size or the file is older than the maximum age
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if w.due(len(p)) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// due reports whether the file must be rotated before writing n more bytes
+func (w *Writer) due(n int) bool {
+	if w.size == 0 {
+		return false
+	}
+	return w.size+int64(n) > w.opts.MaxSize ||
+		(w.opts.MaxAge > 0 && time.Since(w.openedAt) > w.opts.MaxAge)
+}
+
+// Rotate starts a new log file immediately
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	return w.rotate()
+}
+
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("error closing log file: %v", err)
+	}
+	w.file = nil
+
+	rotated := w.path + "." + time.Now().Format(rotatedTimeFormat)
+	if err := os.Rename(w.path, rotated); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error rotating log file: %v", err)
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	// Compress and prune in the background so writers are not held up
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+		w.mill.Lock()
+		defer w.mill.Unlock()
+
+		if w.opts.Compress {
+			if err := compressFile(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "Error compressing log file %s: %v\n", rotated, err)
+			}
+		}
+		if err := w.prune(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error pruning log files of %s: %v\n", w.path, err)
+		}
+	}()
+	return nil
+}
+
+// Close closes the log file once pending compression has finished
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	file := w.file
+	w.file = nil
+	w.mu.Unlock()
+
+	w.pending.Wait()
+	if file == nil {
+		return nil
+	}
+	return file.Close()
+}
+
+// prune removes the oldest rotated files beyond the retention count
+func (w *Writer) prune() error {
+	rotated, err := Rotated(w.path)
+	if err != nil {
+		return err
+	}
+	if len(rotated) <= w.opts.MaxFiles {
+		return nil
+	}
+	for _, name := range rotated[:len(rotated)-w.opts.MaxFiles] {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rotated returns the rotated files of a log, oldest first
+func Rotated(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	rotated := matches[:0]
+	prefix := filepath.Base(path) + "."
+	for _, match := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), prefix), ".gz")
+		if _, err := time.Parse(rotatedTimeFormat, suffix); err == nil {
+			rotated = append(rotated, match)
+		}
+	}
+	sort.Strings(rotated)
+	return rotated, nil
+}
+
+// compressFile gzips a file to name.gz and removes the original
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gw := gzip.NewWriter(dst)
+	if _, err := io.Copy(gw, src); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// RedirectOutput points the stdout and stderr descriptors of the process at
+// stdout.log and stderr.log in the instance's log directory. Everything
+// written to them lands there, including runtime panics and the output of
+// child processes that inherit them, and nothing is lost when the process
+// dies. Since that output doesn't pass through a Writer, the files are
+// checked for rotation every outputCheckInterval.
+func RedirectOutput(name string, cfg *config.LogConfig) error {
+	logDir, err := config.LogDir(name)
+	if err != nil {
+		return fmt.Errorf("error creating log directory: %v", err)
+	}
+	opts := OptionsFrom(cfg)
+
+	var outputs []*Writer
+	for _, output := range []struct {
+		file string
+		fd   int
+	}{{"stdout.log", 1}, {"stderr.log", 2}} {
+		w := &Writer{path: filepath.Join(logDir, output.file), opts: opts, fd: output.fd}
+		if err := w.open(); err != nil {
+			return err
+		}
+		if err := w.rotateIfDue(); err != nil {
+			return err
+		}
+		outputs = append(outputs, w)
+	}
+
+	go func() {
+		for range time.Tick(outputCheckInterval) {
+			for _, w := range outputs {
+				if err := w.rotateIfDue(); err != nil {
+					fmt.Fprintf(os.Stderr, "Error rotating log file %s: %v\n", w.path, err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// rotateIfDue rotates the log when it is due, measuring the file itself since
+// output redirected to it doesn't pass through Write
+func (w *Writer) rotateIfDue() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	info, err := w.file.Stat()
+	if err != nil {
+		return fmt.Errorf("error checking log file: %v", err)
+	}
+	w.size = info.Size()
+	if w.due(0) {
+		return w.rotate()
+	}
+	return nil
+}
+
+// Read returns the last n lines of a log accepted by match, continuing into
+// rotated files when the current one has too few. A zero n returns every
+// matching line. The size of the current file is returned so new lines can
+// be followed from there.
+func Read(path string, n int, match func(string) bool) ([]string, int64, error) {
+	rotated, err := Rotated(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	lines, size, err := readFile(path, match)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, 0, err
+	}
+	for i := len(rotated) - 1; i >= 0 && (n == 0 || len(lines) < n); i-- {
+		older, _, err := readFile(rotated[i], match)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, 0, err
+		}
+		lines = append(older, lines...)
+	}
+
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, size, nil
+}
+
+// readFile returns the lines of a plain or gzipped log file accepted by match
+// along with the number of bytes read
+func readFile(name string, match func(string) bool) ([]string, int64, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(name, ".gz") {
+		gr, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	var (
+		lines []string
+		size  int64
+	)
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		size += int64(len(line))
+		if line = strings.TrimRight(line, "\r\n"); line != "" && match(line) {
+			lines = append(lines, line)
+		}
+		if err == io.EOF {
+			return lines, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// Follow calls fn for every line appended to a log from offset onwards until
+// stop is closed. When the log is rotated it continues with the new file.
+func Follow(path string, offset int64, stop <-chan struct{}, fn func(string)) error {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	var (
+		file    *os.File
+		partial string
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	for {
+		if file == nil {
+			if f, err := os.Open(path); err == nil {
+				if info, err := f.Stat(); err == nil && info.Size() < offset {
+					offset = 0
+				}
+				if _, err := f.Seek(offset, io.SeekStart); err != nil {
+					f.Close()
+					return err
+				}
+				file = f
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		if file != nil {
+			if err := readLines(file, &partial, fn); err != nil {
+				return err
+			}
+
+			// Switch to the new file once the current one has been rotated,
+			// picking up anything written to the old one in the meantime
+			current, statErr := os.Stat(path)
+			opened, err := file.Stat()
+			if err == nil && (statErr != nil || !os.SameFile(current, opened)) {
+				if err := readLines(file, &partial, fn); err != nil {
+					return err
+				}
+				file.Close()
+				file = nil
+				offset = 0
+				if partial != "" {
+					fn(partial)
+					partial = ""
+				}
+				continue
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// readLines calls fn for every complete line read from r, keeping a trailing
+// incomplete line in partial
+func readLines(r io.Reader, partial *string, fn func(string)) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(*partial+string(data), "\n")
+	*partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		fn(strings.TrimRight(line, "\r"))
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package logfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRedirectedDescriptorFollowsRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stdout.log")
+
+	// A spare descriptor stands in for stdout
+	spare, err := os.CreateTemp(dir, "spare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spare.Close()
+	fd := int(spare.Fd())
+
+	w := &Writer{path: path, opts: Options{MaxSize: 16, MaxFiles: 5}, fd: fd}
+	if err := w.open(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err := unix.Write(fd, []byte("written to the descriptor\n")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "written to the descriptor\n" {
+		t.Fatalf("log holds %q", data)
+	}
+
+	// Output that bypassed the Writer still counts towards its size
+	if err := w.rotateIfDue(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unix.Write(fd, []byte("after rotation\n")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "after rotation\n" {
+		t.Errorf("log after rotation holds %q", data)
+	}
+
+	rotated, err := Rotated(path)
+	if err != nil || len(rotated) != 1 {
+		t.Fatalf("rotated files %v, err %v", rotated, err)
+	}
+	if data, _ := os.ReadFile(rotated[0]); !strings.HasPrefix(string(data), "written to the descriptor") {
+		t.Errorf("rotated log holds %q", data)
+	}
+
+	// A file below the limit is left alone
+	if err := w.rotateIfDue(); err != nil {
+		t.Fatal(err)
+	}
+	if rotated, _ := Rotated(path); len(rotated) != 1 {
+		t.Errorf("log below the limit was rotated")
+	}
+}
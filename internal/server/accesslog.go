@@ -0,0 +1,336 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+	"github.com/mguptahub/nanoHttp/internal/logfile"
+)
+
+// Access log formats
+const (
+	LogFormatCommon   = "common"
+	LogFormatCombined = "combined"
+	LogFormatJSON     = "json"
+)
+
+// clfTimeFormat is the timestamp layout of the Common Log Format
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogEntry is a single request as written in the JSON log format
+type AccessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	User       string    `json:"user,omitempty"`
+	Method     string    `json:"method"`
+	Host       string    `json:"host"`
+	Path       string    `json:"path"`
+	Query      string    `json:"query,omitempty"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs float64   `json:"duration_ms"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+// ValidateAccessLog checks that the access log format is known
+func ValidateAccessLog(cfg *config.AccessLogConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	switch cfg.Format {
+	case "", LogFormatCommon, LogFormatCombined, LogFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("invalid access log format %q (expected %s, %s or %s)",
+		cfg.Format, LogFormatCommon, LogFormatCombined, LogFormatJSON)
+}
+
+// AccessLogPath returns the file an instance writes its access log to
+func AccessLogPath(name string, cfg *config.AccessLogConfig) (string, error) {
+	if cfg != nil && cfg.File != "" {
+		return cfg.File, nil
+	}
+	logDir, err := config.LogDir(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(logDir, "access.log"), nil
+}
+
+// accessLogger writes one line per request in the configured format
+type accessLogger struct {
+	mu     sync.Mutex
+	out    io.WriteCloser
+	format string
+}
+
+// openAccessLog opens the access log of an instance for appending, rotated
+// according to the instance's log settings. It returns nil when access
+// logging is disabled.
+func openAccessLog(instance config.InstanceConfig) (*accessLogger, error) {
+	cfg := instance.AccessLog
+	if cfg == nil {
+		return nil, nil
+	}
+
+	path, err := AccessLogPath(instance.Name, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving access log path: %v", err)
+	}
+	file, err := logfile.Open(path, logfile.OptionsFrom(instance.Logs))
+	if err != nil {
+		return nil, fmt.Errorf("error opening access log: %v", err)
+	}
+
+	format := cfg.Format
+	if format == "" {
+		format = LogFormatCombined
+	}
+	return &accessLogger{out: file, format: format}, nil
+}
+
+// Close closes the underlying log file
+func (l *accessLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.out.Close()
+}
+
+// withAccessLog wraps a handler so every completed request is logged
+func withAccessLog(logger *accessLogger, next http.Handler) http.Handler {
+	if logger == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newResponseRecorder(w)
+		user := new(string)
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), loggedUserKey{}, user)))
+
+		logger.log(AccessLogEntry{
+			Time:       start,
+			RemoteAddr: remoteHost(r.RemoteAddr),
+			User:       *user,
+			Method:     r.Method,
+			Host:       r.Host,
+			Path:       r.URL.EscapedPath(),
+			Query:      redactTokenQuery(r.URL.RawQuery),
+			Proto:      r.Proto,
+			Status:     rec.Status(),
+			Bytes:      rec.bytes,
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		})
+	})
+}
+
+func (l *accessLogger) log(entry AccessLogEntry) {
+	var line string
+	switch l.format {
+	case LogFormatJSON:
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		line = string(data) + "\n"
+	case LogFormatCommon:
+		line = commonLogLine(entry) + "\n"
+	default:
+		line = fmt.Sprintf("%s %q %q\n", commonLogLine(entry), orDash(entry.Referer), orDash(entry.UserAgent))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.out, line)
+}
+
+// commonLogLine formats an entry in the Common Log Format
+func commonLogLine(entry AccessLogEntry) string {
+	uri := entry.Path
+	if entry.Query != "" {
+		uri += "?" + entry.Query
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %d",
+		entry.RemoteAddr, orDash(escapeLogField(entry.User)), entry.Time.Format(clfTimeFormat),
+		entry.Method, escapeLogField(uri), entry.Proto,
+		entry.Status, entry.Bytes)
+}
+
+// orDash returns "-" in place of an empty log field
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// remoteHost strips the port from a remote address
+func remoteHost(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// escapeLogField percent-encodes spaces, quotes and control characters, so a
+// field can't split or forge a line of a Common Log Format file
+func escapeLogField(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c <= ' ' || c == '"' || c == 0x7f {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// loggedUserKey holds where withAuth records the user a request signed in as,
+// for the access log
+type loggedUserKey struct{}
+
+// logUser records the user a request signed in as for the access log
+func logUser(r *http.Request, user string) {
+	if logged, ok := r.Context().Value(loggedUserKey{}).(*string); ok {
+		*logged = user
+	}
+}
+
+// commonLogPattern matches Common and Combined Log Format lines
+var commonLogPattern = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]+)\] "(\S+) (\S+) (\S+)" (\d{3}) (\d+|-)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`)
+
+// ParseAccessLogLine parses a line written in any of the access log formats
+func ParseAccessLogLine(line string) (AccessLogEntry, bool) {
+	var entry AccessLogEntry
+	if strings.HasPrefix(line, "{") {
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return entry, false
+		}
+		return entry, true
+	}
+
+	m := commonLogPattern.FindStringSubmatch(line)
+	if m == nil {
+		return entry, false
+	}
+	t, err := time.Parse(clfTimeFormat, m[3])
+	if err != nil {
+		return entry, false
+	}
+	entry.Time = t
+	entry.RemoteAddr = m[1]
+	if m[2] != "-" {
+		entry.User = m[2]
+	}
+	entry.Method = m[4]
+	entry.Path, entry.Query, _ = strings.Cut(m[5], "?")
+	entry.Proto = m[6]
+	entry.Status, _ = strconv.Atoi(m[7])
+	entry.Bytes, _ = strconv.ParseInt(m[8], 10, 64)
+	if m[9] != "-" {
+		entry.Referer, _ = strconv.Unquote(`"` + m[9] + `"`)
+	}
+	if m[10] != "-" {
+		entry.UserAgent, _ = strconv.Unquote(`"` + m[10] + `"`)
+	}
+	return entry, true
+}
+
+// AccessLogFilter selects access log entries by time, status and path
+type AccessLogFilter struct {
+	Since    time.Time
+	Statuses []string
+	Path     string
+}
+
+// ParseStatusFilter parses a comma-separated list of status codes or classes
+// such as "404,5xx"
+func ParseStatusFilter(value string) ([]string, error) {
+	var statuses []string
+	for _, status := range strings.Split(value, ",") {
+		status = strings.ToLower(strings.TrimSpace(status))
+		if status == "" {
+			continue
+		}
+		valid := len(status) == 3 && status[0] >= '1' && status[0] <= '5'
+		if valid && status[1:] != "xx" {
+			_, err := strconv.Atoi(status)
+			valid = err == nil
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid status filter %q (expected a code such as 404 or a class such as 5xx)", status)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Empty reports whether the filter accepts every line
+func (f AccessLogFilter) Empty() bool {
+	return f.Since.IsZero() && len(f.Statuses) == 0 && f.Path == ""
+}
+
+// Match reports whether an access log line passes the filter. Paths match by
+// prefix, or as a glob when they contain wildcards. Lines that cannot be
+// parsed only pass an empty filter.
+func (f AccessLogFilter) Match(line string) bool {
+	if f.Empty() {
+		return true
+	}
+
+	entry, ok := ParseAccessLogLine(line)
+	if !ok {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+
+	if len(f.Statuses) > 0 {
+		code := strconv.Itoa(entry.Status)
+		matched := false
+		for _, status := range f.Statuses {
+			if status == code || (strings.HasSuffix(status, "xx") && status[0] == code[0]) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if f.Path != "" {
+		// Paths are logged escaped, but filtered as they were requested
+		entryPath := entry.Path
+		if unescaped, err := url.PathUnescape(entryPath); err == nil {
+			entryPath = unescaped
+		}
+		if strings.ContainsAny(f.Path, "*?[") {
+			if matched, _ := path.Match(f.Path, entryPath); !matched {
+				return false
+			}
+		} else if !strings.HasPrefix(entryPath, f.Path) {
+			return false
+		}
+	}
+	return true
+}
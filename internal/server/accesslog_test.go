@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+func TestAccessLogEscapesPath(t *testing.T) {
+	for _, format := range []string{LogFormatCommon, LogFormatCombined, LogFormatJSON} {
+		out := &bufferCloser{}
+		logger := &accessLogger{out: out, format: format}
+		request := httptest.NewRequest(http.MethodGet, `/a%0A1.2.3.4%20-%20-%20%22b?q=%0Ax`, nil)
+		withAccessLog(logger, &passedOn{}).ServeHTTP(httptest.NewRecorder(), request)
+
+		logged := out.String()
+		if strings.Count(logged, "\n") != 1 {
+			t.Errorf("%s: request logged as more than one line:\n%s", format, logged)
+			continue
+		}
+		entry, ok := ParseAccessLogLine(strings.TrimSuffix(logged, "\n"))
+		if !ok {
+			t.Errorf("%s: logged line doesn't parse:\n%s", format, logged)
+			continue
+		}
+		if entry.Path != "/a%0A1.2.3.4%20-%20-%20%22b" || entry.Status != http.StatusOK {
+			t.Errorf("%s: parsed path %q, status %d", format, entry.Path, entry.Status)
+		}
+		if !(AccessLogFilter{Path: "/a\n1.2.3.4"}).Match(logged) {
+			t.Errorf("%s: path filter doesn't match the requested path", format)
+		}
+	}
+}
+
+func TestAccessLogUser(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(file, []byte("ann:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	instance := config.InstanceConfig{
+		Name:      "site",
+		BasicAuth: &config.BasicAuthConfig{File: file, Paths: []string{"/private"}},
+	}
+	out := &bufferCloser{}
+	logger := &accessLogger{out: out, format: LogFormatCommon}
+	handler := withAccessLog(logger, withAuth(newAuthenticator(instance), nil, &passedOn{}))
+
+	tests := []struct {
+		target   string
+		user     string
+		password string
+		want     string
+	}{
+		{"/private", "ann", "secret", "ann"},
+		{"/private", "ann", "wrong", ""},
+		{"/private", "forged user\nline", "secret", ""},
+		{"/public", "ann", "secret", ""},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		request := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		request.SetBasicAuth(tt.user, tt.password)
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		entry, ok := ParseAccessLogLine(strings.TrimSuffix(out.String(), "\n"))
+		if !ok {
+			t.Errorf("%s as %q: logged line doesn't parse:\n%s", tt.target, tt.user, out.String())
+			continue
+		}
+		if entry.User != tt.want {
+			t.Errorf("%s as %q: logged user %q, want %q", tt.target, tt.user, entry.User, tt.want)
+		}
+	}
+}
+
+func TestEscapeLogField(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"ann", "ann"},
+		{"ann smith", "ann%20smith"},
+		{"a\"b\r\nc\x7f", "a%22b%0D%0Ac%7F"},
+		{"/päge", "/päge"},
+	}
+	for _, tt := range tests {
+		if got := escapeLogField(tt.value); got != tt.want {
+			t.Errorf("escapeLogField(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// cleanAuthPaths checks that protected path prefixes are absolute and returns
+// them cleaned
+func cleanAuthPaths(prefixes []string) ([]string, error) {
+	var cleaned []string
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("auth path %q must start with /", prefix)
+		}
+		cleaned = append(cleaned, path.Clean(prefix))
+	}
+	return cleaned, nil
+}
+
+// authProtects reports whether a request path is below one of the protected
+// prefixes, or whether there are none. Paths are compared case-insensitively,
+// since the web folder may be on a case-insensitive file system.
+func authProtects(prefixes []string, urlPath string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	urlPath = strings.ToLower(path.Clean("/" + urlPath))
+	for _, prefix := range prefixes {
+		prefix = strings.ToLower(prefix)
+		if prefix == "/" || urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticator checks the credentials of requests to an instance
+type authenticator struct {
+	basic      *config.BasicAuthConfig
+	tokens     *config.TokenAuthConfig
+	signed     *config.SignedURLConfig
+	users      *htpasswdFile
+	store      *tokenStore
+	challenges []string
+}
+
+// newAuthenticator returns the authenticator of an instance, or nil when it
+// doesn't ask for credentials
+func newAuthenticator(cfg config.InstanceConfig) *authenticator {
+	if cfg.BasicAuth == nil && cfg.TokenAuth == nil && cfg.SignedURLs == nil {
+		return nil
+	}
+
+	a := &authenticator{basic: cfg.BasicAuth, tokens: cfg.TokenAuth, signed: cfg.SignedURLs}
+	realm := cfg.Name
+	if a.basic != nil && a.basic.Realm != "" {
+		realm = a.basic.Realm
+	}
+	if a.basic != nil {
+		a.users = &htpasswdFile{name: a.basic.File}
+		a.challenges = append(a.challenges, fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm))
+	}
+	if a.tokens != nil {
+		a.store = newTokenStore(cfg.Name, a.tokens.Tokens)
+		a.challenges = append(a.challenges, fmt.Sprintf("Bearer realm=%q", realm))
+	}
+	return a
+}
+
+// protects reports whether a request path needs credentials
+func (a *authenticator) protects(urlPath string) bool {
+	return (a.basic != nil && authProtects(a.basic.Paths, urlPath)) ||
+		(a.tokens != nil && authProtects(a.tokens.Paths, urlPath)) ||
+		(a.signed != nil && authProtects(a.signed.Paths, urlPath))
+}
+
+// credentials are the user and token a request authenticated with
+type credentials struct {
+	user     string
+	token    config.AccessToken
+	hasUser  bool
+	hasToken bool
+}
+
+// credentialsKey keeps the checked credentials of a request in its context
+type credentialsKey struct{}
+
+// check verifies the Basic auth user or else the token of a request. The
+// result is kept in the context of the returned request, so a later check of
+// the same request doesn't run bcrypt again.
+func (a *authenticator) check(r *http.Request) (credentials, *http.Request) {
+	if c, ok := r.Context().Value(credentialsKey{}).(credentials); ok {
+		return c, r
+	}
+
+	var c credentials
+	if a.users != nil {
+		if user, password, ok := r.BasicAuth(); ok && a.users.authenticate(user, password) {
+			c.user, c.hasUser = user, true
+		}
+	}
+	if !c.hasUser && a.store != nil {
+		if token, ok := requestToken(r); ok {
+			c.token, c.hasToken = a.store.lookup(token)
+		}
+	}
+	return c, r.WithContext(context.WithValue(r.Context(), credentialsKey{}, c))
+}
+
+// identity names the user or token a request authenticated with, if any
+func (c credentials) identity() (string, bool) {
+	if c.hasUser {
+		return "user:" + c.user, true
+	}
+	if c.hasToken {
+		return "token:" + c.token.Name, true
+	}
+	return "", false
+}
+
+// withAuth asks for credentials before serving protected paths: a URL signed
+// for a path below the signed URL prefixes, a user of the instance's htpasswd
+// file, or a token whose paths cover the request. A path is protected when it
+// is below the prefixes of any of these, judged by the path that was
+// requested, before any rewrite. The exempt paths, such as health checks, are
+// always served.
+func withAuth(a *authenticator, exempt []string, next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range exempt {
+			if r.URL.Path == p {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if !a.protects(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A signature only stands in for credentials below its own prefixes
+		expired := false
+		if a.signed != nil && authProtects(a.signed.Paths, r.URL.Path) {
+			var valid bool
+			if valid, expired = checkSignature(a.signed.Secret, r); valid {
+				next.ServeHTTP(w, withoutSignature(r))
+				return
+			}
+		}
+		var c credentials
+		c, r = a.check(r)
+		if c.hasUser {
+			logUser(r, c.user)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if c.hasToken {
+			if !authProtects(c.token.Paths, r.URL.Path) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, withoutToken(r))
+			return
+		}
+
+		// Without a way to sign in, only a signed URL could have been used
+		if len(a.challenges) == 0 {
+			if expired {
+				http.Error(w, "This link has expired", http.StatusForbidden)
+				return
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		for _, challenge := range a.challenges {
+			w.Header().Add("WWW-Authenticate", challenge)
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Prefixes of the htpasswd hash formats that can be verified
+const (
+	apr1Prefix = "$apr1$"
+	shaPrefix  = "{SHA}"
+)
+
+// apr1Alphabet is the base64 alphabet of crypt(3) hashes
+const apr1Alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// resolveBasicAuth checks the Basic auth settings of an instance and returns
+// them with an absolute htpasswd path, using defaultFile when none is set
+func resolveBasicAuth(cfg *config.BasicAuthConfig, defaultFile string) (*config.BasicAuthConfig, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	resolved := *cfg
+	var err error
+	if resolved.Paths, err = cleanAuthPaths(cfg.Paths); err != nil {
+		return nil, err
+	}
+
+	if resolved.File == "" {
+		resolved.File = defaultFile
+		return &resolved, nil
+	}
+
+	if resolved.File, err = filepath.Abs(resolved.File); err != nil {
+		return nil, fmt.Errorf("error converting to absolute path: %v", err)
+	}
+	if info, err := os.Stat(resolved.File); err == nil && info.IsDir() {
+		return nil, fmt.Errorf("htpasswd file %s is a directory", resolved.File)
+	}
+	return &resolved, nil
+}
+
+// htpasswdPath returns the htpasswd file managed for an instance that doesn't
+// bring its own
+func (m *Manager) htpasswdPath(name string) string {
+	return filepath.Join(m.configDir, "auth", name+".htpasswd")
+}
+
+// parseHtpasswd reads the users of an htpasswd file. Users whose hash can't
+// be verified are reported and skipped, so they can't sign in.
+func parseHtpasswd(name string) (map[string]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			fmt.Printf("Warning: Skipping %s line %d: expected user:hash\n", name, n)
+			continue
+		}
+		if !supportedHash(hash) {
+			fmt.Printf("Warning: Skipping user %s in %s: only bcrypt, SHA and APR1 hashes are supported\n", user, name)
+			continue
+		}
+		users[user] = hash
+	}
+	return users, scanner.Err()
+}
+
+// supportedHash reports whether a password hash is in a format that can be
+// verified
+func supportedHash(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", apr1Prefix, shaPrefix} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPassword reports whether a password matches an htpasswd hash
+func checkPassword(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, apr1Prefix):
+		salt, _, ok := strings.Cut(strings.TrimPrefix(hash, apr1Prefix), "$")
+		if !ok {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(apr1Crypt(password, salt)), []byte(hash)) == 1
+	case strings.HasPrefix(hash, shaPrefix):
+		sum := sha1.Sum([]byte(password))
+		encoded := shaPrefix + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(encoded), []byte(hash)) == 1
+	case supportedHash(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	return false
+}
+
+// apr1Crypt hashes a password with Apache's MD5-based crypt variant, as
+// "htpasswd -m" does
+func apr1Crypt(password, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alternate := md5.Sum([]byte(password + salt + password))
+	digest := md5.New()
+	digest.Write(pw)
+	digest.Write([]byte(apr1Prefix + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		digest.Write(alternate[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			digest.Write([]byte{0})
+		} else {
+			digest.Write(pw[:1])
+		}
+	}
+	final := digest.Sum(nil)
+
+	// Slow the hash down with 1000 more rounds
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	var encoded strings.Builder
+	encode := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			encoded.WriteByte(apr1Alphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, group := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint32(final[group[0]])<<16|uint32(final[group[1]])<<8|uint32(final[group[2]]), 4)
+	}
+	encode(uint32(final[11]), 2)
+
+	return apr1Prefix + salt + "$" + encoded.String()
+}
+
+// htpasswdFile holds the users of an htpasswd file, reloading them when the
+// file changes
+type htpasswdFile struct {
+	name    string
+	mu      sync.Mutex
+	checked time.Time
+	file    siteFile
+	users   map[string]string
+
+	// verified remembers the last password each user signed in with, so
+	// bcrypt doesn't run again for every request of a page
+	verified map[string][sha256.Size]byte
+}
+
+// authenticate reports whether a user signed in with their password
+func (h *htpasswdFile) authenticate(user, password string) bool {
+	h.mu.Lock()
+	if time.Since(h.checked) >= siteFilesCheckInterval {
+		h.checked = time.Now()
+		if current := statSiteFile(h.name); current != h.file {
+			h.file = current
+			h.users = nil
+			h.verified = make(map[string][sha256.Size]byte)
+			if current.exists {
+				users, err := parseHtpasswd(h.name)
+				if err != nil {
+					fmt.Printf("Error reading %s: %v\n", h.name, err)
+				}
+				h.users = users
+			}
+		}
+	}
+	hash, ok := h.users[user]
+	verified, cached := h.verified[user]
+	h.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	sum := sha256.Sum256([]byte(hash + "\x00" + password))
+	if cached && subtle.ConstantTimeCompare(sum[:], verified[:]) == 1 {
+		return true
+	}
+	if !checkPassword(hash, password) {
+		return false
+	}
+
+	h.mu.Lock()
+	if h.users[user] == hash {
+		h.verified[user] = sum
+	}
+	h.mu.Unlock()
+	return true
+}
+
+// HtpasswdUsers returns the names of the users in an htpasswd file
+func HtpasswdUsers(name string) ([]string, error) {
+	users, err := parseHtpasswd(name)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for user := range users {
+		names = append(names, user)
+	}
+	return names, nil
+}
+
+// SetHtpasswdUser adds a user to an htpasswd file, or changes the password of
+// an existing one, storing the password as a bcrypt hash. The file is created
+// when it doesn't exist. It reports whether the user already existed.
+func SetHtpasswdUser(name, user, password string) (bool, error) {
+	if user == "" || strings.ContainsAny(user, ":\r\n") {
+		return false, fmt.Errorf("invalid user name %q (must not be empty or contain a colon)", user)
+	}
+	if password == "" {
+		return false, fmt.Errorf("password must not be empty")
+	}
+	if strings.ContainsAny(password, "\r\n") {
+		return false, fmt.Errorf("password must not contain line breaks")
+	}
+	// bcrypt only uses the first 72 bytes, and rejects longer passwords
+	if len(password) > 72 {
+		return false, fmt.Errorf("password is longer than 72 bytes")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return false, fmt.Errorf("error hashing password: %v", err)
+	}
+
+	lines, err := readHtpasswdLines(name)
+	if err != nil {
+		return false, err
+	}
+	entry := user + ":" + string(hash)
+	existed := false
+	for i, line := range lines {
+		if htpasswdLineUser(line) == user {
+			lines[i] = entry
+			existed = true
+			break
+		}
+	}
+	if !existed {
+		lines = append(lines, entry)
+	}
+	return existed, writeHtpasswdLines(name, lines)
+}
+
+// RemoveHtpasswdUser removes a user from an htpasswd file
+func RemoveHtpasswdUser(name, user string) error {
+	lines, err := readHtpasswdLines(name)
+	if err != nil {
+		return err
+	}
+	kept := lines[:0]
+	for _, line := range lines {
+		if htpasswdLineUser(line) != user {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == len(lines) {
+		return fmt.Errorf("user %s not found in %s", user, name)
+	}
+	return writeHtpasswdLines(name, kept)
+}
+
+// readHtpasswdLines returns the lines of an htpasswd file, or none when it
+// doesn't exist yet
+func readHtpasswdLines(name string) ([]string, error) {
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading htpasswd file: %v", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(bytes.TrimRight(data, "\n")), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// writeHtpasswdLines replaces an htpasswd file, so a server reading it never
+// sees a partial file. A new file is only readable by its owner.
+func writeHtpasswdLines(name string, lines []string) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(name); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return fmt.Errorf("error creating htpasswd directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return fmt.Errorf("error writing htpasswd file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	var data strings.Builder
+	for _, line := range lines {
+		data.WriteString(line + "\n")
+	}
+	if _, err := tmp.WriteString(data.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing htpasswd file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing htpasswd file: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return fmt.Errorf("error writing htpasswd file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return fmt.Errorf("error writing htpasswd file: %v", err)
+	}
+	return nil
+}
+
+// htpasswdLineUser returns the user an htpasswd line is for, or an empty
+// string for comments
+func htpasswdLineUser(line string) string {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return ""
+	}
+	user, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+	return user
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Hashes made by other tools: the APR1 ones by "openssl passwd -apr1", the
+// bcrypt one is the Openwall test vector and the SHA one as "htpasswd -s"
+// writes it
+var passwordVectors = []struct {
+	hash     string
+	password string
+}{
+	{"$apr1$r31....$gnsoqlxyxQQ0Ot5JCwiei.", "secret"},
+	{"$apr1$saltsalt$jnlfiUfQg4ZgvsGvlWZek/", "pässwörd with spaces 1234567890"},
+	{"$apr1$ab$S8K6Sgp3W8c9Jb6LxgywZ.", ""},
+	{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "secret"},
+	{"$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "U*U"},
+}
+
+func TestApr1Crypt(t *testing.T) {
+	for _, v := range passwordVectors {
+		if !strings.HasPrefix(v.hash, apr1Prefix) {
+			continue
+		}
+		salt, _, _ := strings.Cut(strings.TrimPrefix(v.hash, apr1Prefix), "$")
+		if got := apr1Crypt(v.password, salt); got != v.hash {
+			t.Errorf("apr1Crypt(%q, %q) = %q, want %q", v.password, salt, got, v.hash)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	for _, v := range passwordVectors {
+		if !checkPassword(v.hash, v.password) {
+			t.Errorf("checkPassword(%q) refused the right password %q", v.hash, v.password)
+		}
+		if checkPassword(v.hash, v.password+"x") {
+			t.Errorf("checkPassword(%q) accepted a wrong password", v.hash)
+		}
+	}
+
+	malformed := []string{
+		"",
+		"secret",
+		"abJnggxhB/yWI",
+		"$apr1$",
+		"$apr1$r31....",
+		"{SHA}",
+		"{SHA}not base64",
+		"$2a$05$CCCCCCCCCCCCCCCCCCCCC.",
+		"$1$r31....$bFoH3qH4sZ.pK9rK3Ik1w/",
+	}
+	for _, hash := range malformed {
+		if checkPassword(hash, "secret") {
+			t.Errorf("checkPassword(%q) accepted a malformed hash", hash)
+		}
+	}
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "users.htpasswd")
+	data := "# team\n" +
+		"\n" +
+		"alice:$apr1$r31....$gnsoqlxyxQQ0Ot5JCwiei.\n" +
+		"bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\r\n" +
+		"  carol:$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW  \n" +
+		"no colon\n" +
+		":$apr1$r31....$gnsoqlxyxQQ0Ot5JCwiei.\n" +
+		"dave:abJnggxhB/yWI\n" +
+		"erin:plaintext\n"
+	if err := os.WriteFile(name, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := parseHtpasswd(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for user := range users {
+		names = append(names, user)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, ","); got != "alice,bob,carol" {
+		t.Fatalf("parsed users %s, want alice,bob,carol", got)
+	}
+	if !checkPassword(users["bob"], "secret") || !checkPassword(users["carol"], "U*U") {
+		t.Error("parsed hashes don't verify their passwords")
+	}
+
+	if _, err := parseHtpasswd(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("parseHtpasswd of a missing file succeeded")
+	}
+}
+
+func TestHtpasswdUserRoundTrip(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "auth", "site.htpasswd")
+
+	authenticate := func(user, password string) bool {
+		return (&htpasswdFile{name: name}).authenticate(user, password)
+	}
+
+	existed, err := SetHtpasswdUser(name, "alice", "first")
+	if err != nil || existed {
+		t.Fatalf("adding alice: existed %v, err %v", existed, err)
+	}
+	if info, err := os.Stat(name); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("new htpasswd file: %v, err %v", info.Mode(), err)
+	}
+	if _, err := SetHtpasswdUser(name, "bob", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if !authenticate("alice", "first") || !authenticate("bob", "other") {
+		t.Fatal("added users can't sign in")
+	}
+
+	existed, err = SetHtpasswdUser(name, "alice", "second")
+	if err != nil || !existed {
+		t.Fatalf("replacing alice: existed %v, err %v", existed, err)
+	}
+	if authenticate("alice", "first") {
+		t.Error("old password still works after replacing it")
+	}
+	if !authenticate("alice", "second") {
+		t.Error("new password doesn't work")
+	}
+	if users, _ := HtpasswdUsers(name); len(users) != 2 {
+		t.Errorf("replacing a user left %d users, want 2", len(users))
+	}
+
+	if err := RemoveHtpasswdUser(name, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if authenticate("alice", "second") {
+		t.Error("removed user can still sign in")
+	}
+	if !authenticate("bob", "other") {
+		t.Error("removing a user removed another one")
+	}
+	if err := RemoveHtpasswdUser(name, "alice"); err == nil {
+		t.Error("removing a missing user succeeded")
+	}
+}
+
+func TestSetHtpasswdUserKeepsOtherLines(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "users.htpasswd")
+	data := "# managed by hand\nbob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"
+	if err := os.WriteFile(name, []byte(data), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SetHtpasswdUser(name, "alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), data+"alice:$2a$") {
+		t.Errorf("file after adding a user:\n%s", content)
+	}
+	if info, _ := os.Stat(name); info.Mode().Perm() != 0640 {
+		t.Errorf("file mode changed to %v", info.Mode().Perm())
+	}
+}
+
+func TestSetHtpasswdUserRejects(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "users.htpasswd")
+	tests := []struct {
+		user     string
+		password string
+	}{
+		{"", "secret"},
+		{"a:b", "secret"},
+		{"a\nb", "secret"},
+		{"alice", ""},
+		{"alice", "line\nbreak"},
+		{"alice", strings.Repeat("x", 73)},
+	}
+	for _, tt := range tests {
+		if _, err := SetHtpasswdUser(name, tt.user, tt.password); err == nil {
+			t.Errorf("SetHtpasswdUser(%q, %q) succeeded", tt.user, tt.password)
+		}
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Error("rejected users created the file")
+	}
+}
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// Supported content encodings, in order of preference
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// DefaultCompressMinSize is the smallest response compressed by default
+const DefaultCompressMinSize = 1024
+
+// DefaultCompressTypes are the MIME types compressed when no allowlist is
+// configured. A trailing "/*" matches a whole type family.
+var DefaultCompressTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/manifest+json",
+	"application/wasm",
+	"application/xml",
+	"image/svg+xml",
+	"image/x-icon",
+}
+
+// incompressibleTypes are never compressed, even when the allowlist matches,
+// since they are already compressed and would only waste CPU
+var incompressibleTypes = map[string]bool{
+	"application/gzip":   true,
+	"application/pdf":    true,
+	"application/x-gzip": true,
+	"application/zip":    true,
+	"application/zstd":   true,
+	"font/woff":          true,
+	"font/woff2":         true,
+	"image/gif":          true,
+	"image/jpeg":         true,
+	"image/png":          true,
+	"image/webp":         true,
+}
+
+var (
+	gzipWriters   = sync.Pool{New: func() interface{} { w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression); return w }}
+	brotliWriters = sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(nil, 5) }}
+)
+
+// ValidateCompression checks that only supported encodings are configured
+func ValidateCompression(cfg *config.CompressionConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	for _, encoding := range cfg.Encodings {
+		if encoding != encodingBrotli && encoding != encodingGzip {
+			return fmt.Errorf("unsupported compression encoding %q (expected br or gzip)", encoding)
+		}
+	}
+	if cfg.MinSize < 0 {
+		return fmt.Errorf("compression minimum size must not be negative")
+	}
+	return nil
+}
+
+// compressor compresses responses on the fly according to Accept-Encoding
+type compressor struct {
+	encodings []string
+	minSize   int64
+	types     []string
+}
+
+// withCompression wraps a handler with on-the-fly response compression
+func withCompression(cfg *config.CompressionConfig, next http.Handler) http.Handler {
+	if cfg == nil {
+		return next
+	}
+
+	c := &compressor{
+		encodings: cfg.Encodings,
+		minSize:   int64(cfg.MinSize),
+		types:     cfg.MimeTypes,
+	}
+	if len(c.encodings) == 0 {
+		c.encodings = []string{encodingBrotli, encodingGzip}
+	}
+	if c.minSize == 0 {
+		c.minSize = DefaultCompressMinSize
+	}
+	if len(c.types) == 0 {
+		c.types = DefaultCompressTypes
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &compressWriter{ResponseWriter: w, c: c, r: r}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiate picks the preferred supported encoding accepted by the client,
+// or an empty string for identity
+func (c *compressor) negotiate(acceptEncoding string) string {
+	accepted := acceptedEncodings(acceptEncoding)
+
+	best, bestQ := "", 0.0
+	for _, encoding := range c.encodings {
+		if q := encodingQuality(accepted, encoding); q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// acceptedEncodings parses an Accept-Encoding header into a map of encoding
+// names to their quality values
+func acceptedEncodings(acceptEncoding string) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+	return accepted
+}
+
+// encodingQuality returns the quality the client assigned to an encoding,
+// which is zero when the encoding is not acceptable
+func encodingQuality(accepted map[string]float64, encoding string) float64 {
+	if q, ok := accepted[encoding]; ok {
+		return q
+	}
+	return accepted["*"]
+}
+
+// compressible reports whether a response with the given headers is worth
+// compressing, regardless of what the client accepts
+func (c *compressor) compressible(header http.Header) bool {
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	if length := header.Get("Content-Length"); length != "" {
+		if n, err := strconv.ParseInt(length, 10, 64); err == nil && n < c.minSize {
+			return false
+		}
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || incompressibleTypes[mediaType] {
+		return false
+	}
+	for _, pattern := range c.types {
+		if pattern == mediaType || pattern == "*/*" {
+			return true
+		}
+		if family, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(mediaType, family+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter decides when the header is written whether to compress the
+// body, and then routes writes through the chosen encoder. A body of unknown
+// length is held back until it reaches the minimum size, and sent as it is
+// when it ends first.
+type compressWriter struct {
+	http.ResponseWriter
+	c           *compressor
+	r           *http.Request
+	wroteHeader bool
+	status      int
+	pending     string
+	buffered    []byte
+	encoding    string
+	encoder     io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	header := cw.Header()
+	if code == http.StatusOK && cw.c.compressible(header) {
+		header.Add("Vary", "Accept-Encoding")
+
+		// Partial and header-only responses must describe the identity body
+		if cw.r.Method != http.MethodHead && cw.r.Header.Get("Range") == "" {
+			encoding := cw.c.negotiate(cw.r.Header.Get("Accept-Encoding"))
+			if encoding != "" && header.Get("Content-Length") == "" {
+				cw.pending = encoding
+				cw.status = code
+				return
+			}
+			cw.start(encoding)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+// commit sends the held back header, switching to the given encoding unless
+// it is empty, followed by the body buffered so far
+func (cw *compressWriter) commit(encoding string) error {
+	buffered := cw.buffered
+	cw.pending, cw.buffered = "", nil
+
+	cw.start(encoding)
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(buffered) == 0 {
+		return nil
+	}
+	_, err := cw.Write(buffered)
+	return err
+}
+
+// start switches the response to the given encoding
+func (cw *compressWriter) start(encoding string) {
+	switch encoding {
+	case encodingGzip:
+		gw := gzipWriters.Get().(*gzip.Writer)
+		gw.Reset(cw.ResponseWriter)
+		cw.encoder = gw
+	case encodingBrotli:
+		bw := brotliWriters.Get().(*brotli.Writer)
+		bw.Reset(cw.ResponseWriter)
+		cw.encoder = bw
+	default:
+		return
+	}
+
+	cw.encoding = encoding
+	header := cw.Header()
+	header.Set("Content-Encoding", encoding)
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	// A strong ETag must not be shared between encodings
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		// Let the content type be sniffed the same way net/http would
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.pending != "" {
+		cw.buffered = append(cw.buffered, b...)
+		if int64(len(cw.buffered)) >= cw.c.minSize {
+			if err := cw.commit(cw.pending); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush flushes any compressed data buffered so far to the client. A body
+// still held back is compressed, since a streamed response may go on for
+// long.
+func (cw *compressWriter) Flush() {
+	if cw.pending != "" {
+		cw.commit(cw.pending)
+	}
+	if cw.encoder != nil {
+		if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+			f.Flush()
+		}
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the compressed stream and returns the encoder to its pool.
+// A body that ended before reaching the minimum size is sent as it is.
+func (cw *compressWriter) Close() error {
+	if cw.pending != "" {
+		cw.Header().Set("Content-Length", strconv.Itoa(len(cw.buffered)))
+		if err := cw.commit(""); err != nil {
+			return err
+		}
+	}
+	if cw.encoder == nil {
+		return nil
+	}
+
+	err := cw.encoder.Close()
+	switch cw.encoding {
+	case encodingGzip:
+		gzipWriters.Put(cw.encoder)
+	case encodingBrotli:
+		brotliWriters.Put(cw.encoder)
+	}
+	cw.encoder = nil
+	return err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// serveCompressed sends a gzip-accepting request to handler behind
+// compression with a minimum size of 100 bytes
+func serveCompressed(t *testing.T, handler http.HandlerFunc) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	cfg := &config.CompressionConfig{Encodings: []string{encodingGzip}, MinSize: 100}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	withCompression(cfg, handler).ServeHTTP(rec, r)
+
+	body := rec.Body.String()
+	if rec.Header().Get("Content-Encoding") == encodingGzip {
+		gr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(gr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = string(data)
+	}
+	return rec, body
+}
+
+// writeChunks writes a text body in chunks without a Content-Length
+func writeChunks(chunks ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		for _, chunk := range chunks {
+			io.WriteString(w, chunk)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	small := strings.Repeat("a", 60)
+	large := strings.Repeat("b", 150)
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		compressed bool
+		body       string
+	}{
+		{"small streamed body", writeChunks(small[:30], small[30:]), false, small},
+		{"large streamed body", writeChunks(large[:50], large[50:100], large[100:]), true, large},
+		{"body reaching the minimum", writeChunks(strings.Repeat("c", 100)), true, strings.Repeat("c", 100)},
+		{"empty body", writeChunks(), false, ""},
+		{"small body with a length", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", strconv.Itoa(len(small)))
+			io.WriteString(w, small)
+		}, false, small},
+		{"large body with a length", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+			io.WriteString(w, large)
+		}, true, large},
+		{"incompressible type", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, large)
+		}, false, large},
+		{"error status", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, large)
+		}, false, large},
+	}
+	for _, tt := range tests {
+		rec, body := serveCompressed(t, tt.handler)
+		if compressed := rec.Header().Get("Content-Encoding") == encodingGzip; compressed != tt.compressed {
+			t.Errorf("%s: compressed %v, want %v", tt.name, compressed, tt.compressed)
+		}
+		if body != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, body, tt.body)
+		}
+	}
+}
+
+func TestCompressionSmallBodyLength(t *testing.T) {
+	rec, _ := serveCompressed(t, writeChunks("short", " body"))
+	if rec.Header().Get("Content-Length") != "10" {
+		t.Errorf("Content-Length %q, want 10", rec.Header().Get("Content-Length"))
+	}
+	if rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Error("Vary header missing")
+	}
+}
+
+func TestCompressionFlushStartsStream(t *testing.T) {
+	rec, body := serveCompressed(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		if !flushedThrough(w) {
+			t.Error("header wasn't sent by the flush")
+		}
+		io.WriteString(w, "data: 2\n\n")
+	})
+	if rec.Header().Get("Content-Encoding") != encodingGzip {
+		t.Error("flushed stream wasn't compressed")
+	}
+	if body != "data: 1\n\ndata: 2\n\n" {
+		t.Errorf("body %q", body)
+	}
+}
+
+// flushedThrough reports whether the recorder under a compressing writer was
+// flushed
+func flushedThrough(w http.ResponseWriter) bool {
+	return w.(*compressWriter).ResponseWriter.(*httptest.ResponseRecorder).Flushed
+}
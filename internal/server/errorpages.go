@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ParseErrorPage parses a "CODE=PATH" mapping such as "404=errors/404.html"
+func ParseErrorPage(value string) (int, string, error) {
+	codeStr, page, ok := strings.Cut(value, "=")
+	if !ok || page == "" {
+		return 0, "", fmt.Errorf("invalid error page %q (expected CODE=PATH)", value)
+	}
+
+	code, err := strconv.Atoi(strings.TrimSpace(codeStr))
+	if err != nil || code < 400 || code > 599 {
+		return 0, "", fmt.Errorf("invalid error page status code %q (expected 400-599)", codeStr)
+	}
+	return code, strings.TrimSpace(page), nil
+}
+
+// errorPagePath resolves an error page relative to the web folder, refusing
+// paths that escape it
+func errorPagePath(webFolder, page string) (string, error) {
+	path := filepath.Join(webFolder, filepath.FromSlash(page))
+	rel, err := filepath.Rel(webFolder, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("error page %s is outside the web folder", page)
+	}
+	return path, nil
+}
+
+// validateErrorPages checks that every configured error page exists inside
+// the web folder
+func validateErrorPages(webFolder string, pages map[int]string) error {
+	for code, page := range pages {
+		path, err := errorPagePath(webFolder, page)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			return fmt.Errorf("error page %s for status %d not found in web folder", page, code)
+		}
+	}
+	return nil
+}
+
+// withErrorPages replaces the body of error responses with the configured
+// page for their status code. Pages are read on every error so edits show up
+// without a restart. Panics are turned into 500 responses so they can be
+// served a custom page too.
+func withErrorPages(webFolder string, pages map[int]string, next http.Handler) http.Handler {
+	if len(pages) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &errorPageWriter{ResponseWriter: w, r: r, webFolder: webFolder, pages: pages}
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler || ew.wroteHeader {
+					panic(err)
+				}
+				fmt.Printf("Panic serving %s: %v\n", r.URL.Path, err)
+				ew.WriteHeader(http.StatusInternalServerError)
+				if !ew.intercepted {
+					w.Write([]byte(http.StatusText(http.StatusInternalServerError) + "\n"))
+				}
+			}
+		}()
+		next.ServeHTTP(ew, r)
+	})
+}
+
+// errorPageWriter swaps the body of responses whose status has a custom page
+type errorPageWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	webFolder   string
+	pages       map[int]string
+	wroteHeader bool
+	intercepted bool
+}
+
+func (ew *errorPageWriter) WriteHeader(code int) {
+	if ew.wroteHeader {
+		return
+	}
+	ew.wroteHeader = true
+
+	page, ok := ew.pages[code]
+	if !ok {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	path, err := errorPagePath(ew.webFolder, page)
+	if err != nil {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("Error reading error page %s: %v\n", path, err)
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+
+	header := ew.Header()
+	header.Del("Content-Encoding")
+	header.Del("Content-Range")
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	ew.intercepted = true
+	ew.ResponseWriter.WriteHeader(code)
+	if ew.r.Method != http.MethodHead {
+		ew.ResponseWriter.Write(body)
+	}
+}
+
+func (ew *errorPageWriter) Write(b []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	// The original error body is dropped in favor of the custom page
+	if ew.intercepted {
+		return len(b), nil
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (ew *errorPageWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
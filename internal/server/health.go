@@ -0,0 +1,176 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// Default health endpoint paths
+const (
+	DefaultLivenessPath  = "/healthz"
+	DefaultReadinessPath = "/readyz"
+)
+
+// Version is reported by the health endpoints; the CLI sets it at startup
+var Version = "dev"
+
+// Health statuses
+const (
+	HealthOK          = "ok"
+	HealthUnavailable = "unavailable"
+)
+
+// HealthStatus is the JSON body of the health endpoints
+type HealthStatus struct {
+	Status  string            `json:"status"`
+	Name    string            `json:"name"`
+	Version string            `json:"version"`
+	Uptime  string            `json:"uptime"`
+	Checks  map[string]string `json:"checks,omitempty"`
+}
+
+// ValidateHealth checks that the health endpoint paths are valid and don't
+// collide with each other or with metrics served on the same port
+func ValidateHealth(cfg *config.HealthConfig, metrics *config.MetricsConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	for _, path := range []string{cfg.LivenessPath, cfg.ReadinessPath} {
+		if path != "" && !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("health path %q must start with /", path)
+		}
+	}
+
+	liveness, readiness := healthPaths(cfg)
+	if liveness == readiness {
+		return fmt.Errorf("liveness and readiness paths must differ")
+	}
+	if metrics != nil && metrics.Port == 0 {
+		if path := metricsPath(metrics); path == liveness || path == readiness {
+			return fmt.Errorf("health path %s is already used for metrics", path)
+		}
+	}
+	return nil
+}
+
+// healthPaths returns the liveness and readiness paths of an instance
+func healthPaths(cfg *config.HealthConfig) (string, string) {
+	liveness, readiness := cfg.LivenessPath, cfg.ReadinessPath
+	if liveness == "" {
+		liveness = DefaultLivenessPath
+	}
+	if readiness == "" {
+		readiness = DefaultReadinessPath
+	}
+	return liveness, readiness
+}
+
+// healthHandler reports that the server is alive. The readiness variant also
+// checks that the web folder and every mounted folder can still be read and
+// answers 503 otherwise.
+func (s *Server) healthHandler(webFolder string, readiness bool) http.Handler {
+	st := s.stats
+	name := s.config.Name
+	folders := map[string]string{"web_folder": webFolder}
+	for _, mount := range s.config.Mounts {
+		folders["mount "+mount.Prefix] = mount.Folder
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := HealthStatus{
+			Status:  HealthOK,
+			Name:    name,
+			Version: Version,
+			Uptime:  time.Since(st.startedAt).Round(time.Second).String(),
+		}
+
+		code := http.StatusOK
+		if readiness {
+			status.Checks = make(map[string]string, len(folders))
+			for check, dir := range folders {
+				status.Checks[check] = HealthOK
+				if err := checkReadable(dir); err != nil {
+					status.Status = HealthUnavailable
+					status.Checks[check] = err.Error()
+					code = http.StatusServiceUnavailable
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		if r.Method != http.MethodHead {
+			json.NewEncoder(w).Encode(status)
+		}
+	})
+}
+
+// checkReadable verifies that a directory exists and its entries can be read
+func checkReadable(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if _, err := f.Readdirnames(1); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// CheckHealth probes the readiness endpoint of a running instance on
+// localhost
+func CheckHealth(instance config.InstanceConfig) (*HealthStatus, error) {
+	if instance.Health == nil {
+		return nil, fmt.Errorf("health endpoints are not enabled")
+	}
+	_, readiness := healthPaths(instance.Health)
+
+	// A virtual host is only reached under one of its own hostnames
+	host := probeHost(instance)
+
+	scheme := "http"
+	transport := &http.Transport{}
+	if instance.TLS != nil {
+		scheme = "https"
+		// The probe only ever talks to this machine, where the certificate
+		// need not be valid for "localhost"
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true, ServerName: host}
+	}
+	client := &http.Client{Timeout: 2 * time.Second, Transport: transport}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://localhost:%d%s", scheme, instance.Port, readiness), nil)
+	if err != nil {
+		return nil, err
+	}
+	if host != "" {
+		req.Host = host
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var status HealthStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("unexpected response from %s (status %d)", readiness, resp.StatusCode)
+	}
+	return &status, nil
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// ValidateIPFilter checks that every entry of the allow, deny and trusted
+// proxy lists is an IP address or CIDR block
+func ValidateIPFilter(cfg *config.IPFilterConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	for _, list := range [][]string{cfg.Allow, cfg.Deny, cfg.TrustedProxies} {
+		if _, err := parseIPRanges(list); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseIPRanges parses IP addresses and CIDR blocks. An address is a block
+// of its own.
+func parseIPRanges(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR block %q: %v", value, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q (expected an address or a CIDR block)", value)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// matchIPRanges returns the block of a list that contains an address
+func matchIPRanges(prefixes []netip.Prefix, addr netip.Addr) (netip.Prefix, bool) {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return prefix, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// ipFilter is an instance's IP filter with its lists parsed
+type ipFilter struct {
+	allow   []netip.Prefix
+	deny    []netip.Prefix
+	trusted []netip.Prefix
+}
+
+// trustedProxies returns the parsed trusted proxies of an instance
+func trustedProxies(cfg *config.IPFilterConfig) []netip.Prefix {
+	if cfg == nil {
+		return nil
+	}
+	trusted, _ := parseIPRanges(cfg.TrustedProxies)
+	return trusted
+}
+
+// clientIP returns the address of the client that sent a request. Behind a
+// trusted proxy, it is the last address of X-Forwarded-For that was not added
+// by a trusted proxy.
+func clientIP(trusted []netip.Prefix, r *http.Request) (netip.Addr, bool) {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	client := addrPort.Addr().Unmap().WithZone("")
+	if _, ok := matchIPRanges(trusted, client); !ok {
+		return client, true
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// An address that can't be read can't be trusted either
+			return netip.Addr{}, false
+		}
+		client = hop.Unmap().WithZone("")
+		if _, ok := matchIPRanges(trusted, client); !ok {
+			break
+		}
+	}
+	return client, true
+}
+
+// clientAddress returns the address of the client that sent a request as
+// clientIP finds it, or else the address the request came from
+func clientAddress(trusted []netip.Prefix, r *http.Request) string {
+	if client, ok := clientIP(trusted, r); ok {
+		return client.String()
+	}
+	return remoteHost(r.RemoteAddr)
+}
+
+// check reports whether a client may be served, and why not otherwise. The
+// deny list wins over the allow list, and a non-empty allow list refuses
+// everyone it doesn't contain.
+func (f *ipFilter) check(client netip.Addr) (bool, string) {
+	if prefix, ok := matchIPRanges(f.deny, client); ok {
+		return false, "denied by " + prefix.String()
+	}
+	if len(f.allow) == 0 {
+		return true, ""
+	}
+	if _, ok := matchIPRanges(f.allow, client); ok {
+		return true, ""
+	}
+	return false, "not in the allow list"
+}
+
+// withIPFilter answers 403 Forbidden to clients the instance's IP filter
+// refuses, and logs each refusal. Health checks are filtered too, since they
+// tell the version and uptime of the server.
+func withIPFilter(name string, cfg *config.IPFilterConfig, next http.Handler) http.Handler {
+	if cfg == nil || len(cfg.Allow) == 0 && len(cfg.Deny) == 0 {
+		return next
+	}
+
+	var f ipFilter
+	lists := []*[]netip.Prefix{&f.allow, &f.deny, &f.trusted}
+	for i, values := range [][]string{cfg.Allow, cfg.Deny, cfg.TrustedProxies} {
+		prefixes, err := parseIPRanges(values)
+		if err != nil {
+			// Refuse everyone rather than serve with a broken list
+			fmt.Printf("Warning: IP filter of %s is invalid, refusing all requests: %v\n", name, err)
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+			})
+		}
+		*lists[i] = prefixes
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from, reason := "unknown client", "unreadable client address"
+		if client, ok := clientIP(f.trusted, r); ok {
+			if ok, reason = f.check(client); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			from = client.String()
+		}
+		fmt.Printf("Denied %s %s for %s from %s (via %s): %s\n", r.Method, r.URL.Path, name, from, remoteHost(r.RemoteAddr), reason)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+func TestParseIPRanges(t *testing.T) {
+	prefixes, err := parseIPRanges([]string{"192.0.2.7", " 10.1.2.3/8 ", "2001:db8::/32", "::ffff:198.51.100.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.0.2.7/32", "10.0.0.0/8", "2001:db8::/32", "198.51.100.1/32"}
+	for i, prefix := range prefixes {
+		if prefix.String() != want[i] {
+			t.Errorf("parsed %s, want %s", prefix, want[i])
+		}
+	}
+
+	for _, value := range []string{"", "example.com", "10.0.0.0/33", "10.0.0.256", "10.0.0.0/"} {
+		if _, err := parseIPRanges([]string{value}); err == nil {
+			t.Errorf("parseIPRanges(%q) succeeded", value)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseIPRanges([]string{"10.0.0.0/8", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		client     string
+	}{
+		{"direct", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"untrusted peer can't forward", "203.0.113.5:1234", []string{"198.51.100.7"}, "203.0.113.5"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"trusted proxy without header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"chain of proxies", "10.0.0.1:1234", []string{"198.51.100.7, 10.0.0.2, 10.0.0.3"}, "198.51.100.7"},
+		{"spoofed left-most entries", "10.0.0.1:1234", []string{"127.0.0.1, 192.0.2.1, 198.51.100.7"}, "198.51.100.7"},
+		{"spoofed trusted entry", "10.0.0.1:1234", []string{"10.9.9.9, 198.51.100.7"}, "198.51.100.7"},
+		{"repeated headers", "10.0.0.1:1234", []string{"192.0.2.1", "198.51.100.7"}, "198.51.100.7"},
+		{"only proxies", "10.0.0.1:1234", []string{"10.0.0.2"}, "10.0.0.2"},
+		{"IPv4-mapped peer", "[::ffff:10.0.0.1]:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"IPv4-mapped hop", "10.0.0.1:1234", []string{"::ffff:198.51.100.7"}, "198.51.100.7"},
+		{"IPv6 proxy", "[fd00::1]:1234", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"zoned peer", "[fe80::1%eth0]:1234", nil, "fe80::1"},
+		{"unparseable hop left of the client", "10.0.0.1:1234", []string{"garbage, 198.51.100.7"}, "198.51.100.7"},
+		{"unparseable hop", "10.0.0.1:1234", []string{"198.51.100.7, garbage"}, ""},
+		{"unparseable peer", "pipe", nil, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, value := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+
+		client, ok := clientIP(trusted, r)
+		if tt.client == "" {
+			if ok {
+				t.Errorf("%s: clientIP = %s, want none", tt.name, client)
+			}
+			continue
+		}
+		if !ok || client != netip.MustParseAddr(tt.client) {
+			t.Errorf("%s: clientIP = %s, %v, want %s", tt.name, client, ok, tt.client)
+		}
+	}
+}
+
+func TestIPFilterCheck(t *testing.T) {
+	parse := func(values ...string) []netip.Prefix {
+		prefixes, err := parseIPRanges(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return prefixes
+	}
+
+	tests := []struct {
+		name   string
+		filter ipFilter
+		client string
+		ok     bool
+	}{
+		{"allowed", ipFilter{allow: parse("192.0.2.0/24")}, "192.0.2.7", true},
+		{"not allowed", ipFilter{allow: parse("192.0.2.0/24")}, "198.51.100.7", false},
+		{"deny over allow", ipFilter{allow: parse("192.0.2.0/24"), deny: parse("192.0.2.7")}, "192.0.2.7", false},
+		{"allowed next to a denied one", ipFilter{allow: parse("192.0.2.0/24"), deny: parse("192.0.2.7")}, "192.0.2.8", true},
+		{"empty allow list", ipFilter{deny: parse("192.0.2.0/24")}, "198.51.100.7", true},
+		{"denied with empty allow list", ipFilter{deny: parse("192.0.2.0/24")}, "192.0.2.7", false},
+		{"IPv4 list and IPv6 client", ipFilter{allow: parse("192.0.2.0/24")}, "2001:db8::7", false},
+	}
+	for _, tt := range tests {
+		ok, reason := tt.filter.check(netip.MustParseAddr(tt.client))
+		if ok != tt.ok {
+			t.Errorf("%s: check(%s) = %v (%s), want %v", tt.name, tt.client, ok, reason, tt.ok)
+		}
+		if !ok && reason == "" {
+			t.Errorf("%s: refused without a reason", tt.name)
+		}
+	}
+}
+
+func TestIPFilterCoversHealthChecks(t *testing.T) {
+	webFolder := t.TempDir()
+	if err := os.WriteFile(filepath.Join(webFolder, "index.html"), []byte("home"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(config.InstanceConfig{
+		Name:      "site",
+		WebFolder: webFolder,
+		Health:    &config.HealthConfig{},
+		IPFilter: &config.IPFilterConfig{
+			Allow:          []string{"192.0.2.0/24"},
+			TrustedProxies: []string{"10.0.0.1"},
+		},
+	})
+	handler := s.CreateHandler()
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		status     int
+	}{
+		{"192.0.2.7:1234", "", http.StatusOK},
+		{"198.51.100.7:1234", "", http.StatusForbidden},
+		{"198.51.100.7:1234", "192.0.2.7", http.StatusForbidden},
+		{"10.0.0.1:1234", "192.0.2.7", http.StatusOK},
+		{"10.0.0.1:1234", "198.51.100.7", http.StatusForbidden},
+	}
+	for _, target := range []string{"/", DefaultLivenessPath, DefaultReadinessPath} {
+		for _, tt := range tests {
+			r := httptest.NewRequest(http.MethodGet, target, nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			if rec.Code != tt.status {
+				t.Errorf("%s from %s (for %q): status %d, want %d", target, tt.remoteAddr, tt.forwarded, rec.Code, tt.status)
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// DefaultMetricsPath is where metrics are served when no path is configured
+const DefaultMetricsPath = "/metrics"
+
+// processStart is when this process started, shared by every instance it hosts
+var processStart = time.Now()
+
+// latencyBuckets are the upper bounds of the request duration histogram, in
+// seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricMethods are reported by name; any other method is counted as OTHER
+// to keep the number of series bounded
+var metricMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// ValidateMetrics checks the metrics path and port
+func ValidateMetrics(port int, cfg *config.MetricsConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.Path != "" && !strings.HasPrefix(cfg.Path, "/") {
+		return fmt.Errorf("metrics path %q must start with /", cfg.Path)
+	}
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return fmt.Errorf("invalid metrics port %d", cfg.Port)
+	}
+	if cfg.Port == port {
+		return fmt.Errorf("metrics port %d is the instance's own port", cfg.Port)
+	}
+	return nil
+}
+
+// metricsPath returns the path metrics are served on
+func metricsPath(cfg *config.MetricsConfig) string {
+	if cfg.Path == "" {
+		return DefaultMetricsPath
+	}
+	return cfg.Path
+}
+
+// requestLabels identifies a request counter series
+type requestLabels struct {
+	method string
+	class  string
+}
+
+// requestMetrics holds the labeled request counters and the latency
+// histogram of a server
+type requestMetrics struct {
+	mu       sync.Mutex
+	requests map[requestLabels]uint64
+	buckets  []uint64
+	count    uint64
+	sum      float64
+}
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{
+		requests: make(map[requestLabels]uint64),
+		buckets:  make([]uint64, len(latencyBuckets)),
+	}
+}
+
+// observe records a completed request
+func (m *requestMetrics) observe(method string, status int, duration time.Duration) {
+	if !metricMethods[method] {
+		method = "OTHER"
+	}
+	labels := requestLabels{method: method, class: fmt.Sprintf("%dxx", status/100)}
+	seconds := duration.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[labels]++
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+		}
+	}
+	m.count++
+	m.sum += seconds
+}
+
+// metricsHandler serves the server's metrics in the Prometheus text
+// exposition format
+func (s *Server) metricsHandler() http.Handler {
+	st := s.stats
+	name := s.config.Name
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method == http.MethodHead {
+			return
+		}
+		writeMetrics(w, name, st)
+	})
+}
+
+// writeMetrics writes every metric of a server, labeled with its name
+func writeMetrics(w io.Writer, name string, st *stats) {
+	server := fmt.Sprintf("server=%q", name)
+	m := st.metrics
+
+	m.mu.Lock()
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].method != labels[j].method {
+			return labels[i].method < labels[j].method
+		}
+		return labels[i].class < labels[j].class
+	})
+	requests := make([]uint64, len(labels))
+	for i, l := range labels {
+		requests[i] = m.requests[l]
+	}
+	buckets := append([]uint64(nil), m.buckets...)
+	count, sum := m.count, m.sum
+	m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP nanohttp_requests_total Requests served, by method and status class.\n")
+	fmt.Fprintf(w, "# TYPE nanohttp_requests_total counter\n")
+	for i, l := range labels {
+		fmt.Fprintf(w, "nanohttp_requests_total{%s,method=%q,code=%q} %d\n", server, l.method, l.class, requests[i])
+	}
+
+	fmt.Fprintf(w, "# HELP nanohttp_request_duration_seconds Time taken to serve requests.\n")
+	fmt.Fprintf(w, "# TYPE nanohttp_request_duration_seconds histogram\n")
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(w, "nanohttp_request_duration_seconds_bucket{%s,le=%q} %d\n", server, formatFloat(bound), buckets[i])
+	}
+	fmt.Fprintf(w, "nanohttp_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", server, count)
+	fmt.Fprintf(w, "nanohttp_request_duration_seconds_sum{%s} %s\n", server, formatFloat(sum))
+	fmt.Fprintf(w, "nanohttp_request_duration_seconds_count{%s} %d\n", server, count)
+
+	fmt.Fprintf(w, "# HELP nanohttp_response_bytes_total Response body bytes sent.\n")
+	fmt.Fprintf(w, "# TYPE nanohttp_response_bytes_total counter\n")
+	fmt.Fprintf(w, "nanohttp_response_bytes_total{%s} %d\n", server, st.bytesSent.Load())
+
+	fmt.Fprintf(w, "# HELP nanohttp_requests_in_flight Requests currently being served.\n")
+	fmt.Fprintf(w, "# TYPE nanohttp_requests_in_flight gauge\n")
+	fmt.Fprintf(w, "nanohttp_requests_in_flight{%s} %d\n", server, st.inFlight.Load())
+
+	fmt.Fprintf(w, "# HELP nanohttp_rate_limited_requests_total Requests refused with 429 Too Many Requests, by limit.\n")
+	fmt.Fprintf(w, "# TYPE nanohttp_rate_limited_requests_total counter\n")
+	fmt.Fprintf(w, "nanohttp_rate_limited_requests_total{%s,limit=\"rate\"} %d\n", server, st.rateLimited.Load())
+	fmt.Fprintf(w, "nanohttp_rate_limited_requests_total{%s,limit=\"concurrency\"} %d\n", server, st.concurrencyLimited.Load())
+
+	fmt.Fprintf(w, "# HELP nanohttp_uptime_seconds Time since the server started.\n")
+	fmt.Fprintf(w, "# TYPE nanohttp_uptime_seconds gauge\n")
+	fmt.Fprintf(w, "nanohttp_uptime_seconds{%s} %s\n", server, formatFloat(time.Since(st.startedAt).Seconds()))
+
+	fmt.Fprintf(w, "# HELP process_start_time_seconds Start time of the process since the Unix epoch.\n")
+	fmt.Fprintf(w, "# TYPE process_start_time_seconds gauge\n")
+	fmt.Fprintf(w, "process_start_time_seconds %s\n", formatFloat(float64(processStart.UnixNano())/1e9))
+
+	fmt.Fprintf(w, "# HELP process_uptime_seconds Time since the process started.\n")
+	fmt.Fprintf(w, "# TYPE process_uptime_seconds gauge\n")
+	fmt.Fprintf(w, "process_uptime_seconds %s\n", formatFloat(time.Since(processStart).Seconds()))
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// ParseMount parses a "PREFIX=PATH[;option...]" mount such as
+// "/docs=./site;spa". Options are:
+//
+//	listing               allow directory listings below the prefix
+//	spa                   serve index.html for paths that don't match a file
+//	spa-fallback=FILE     serve FILE instead of index.html in SPA mode
+func ParseMount(value string) (config.Mount, error) {
+	var mount config.Mount
+
+	parts := strings.Split(value, ";")
+	prefix, folder, ok := strings.Cut(parts[0], "=")
+	if !ok || strings.TrimSpace(prefix) == "" || strings.TrimSpace(folder) == "" {
+		return mount, fmt.Errorf("invalid mount %q (expected PREFIX=PATH)", value)
+	}
+	mount.Prefix = strings.TrimSpace(prefix)
+	mount.Folder = strings.TrimSpace(folder)
+
+	for _, option := range parts[1:] {
+		option = strings.TrimSpace(option)
+		name, arg, _ := strings.Cut(option, "=")
+		switch name {
+		case "":
+		case "listing":
+			mount.AllowDirListing = true
+		case "spa":
+			mount.SPA = true
+		case "spa-fallback":
+			if arg == "" {
+				return mount, fmt.Errorf("invalid mount option %q (expected spa-fallback=FILE)", option)
+			}
+			mount.SPA = true
+			mount.SPAFallback = arg
+		default:
+			return mount, fmt.Errorf("unknown mount option %q", option)
+		}
+	}
+	return mount, nil
+}
+
+// resolveMounts checks that every mount has a usable prefix that no other
+// mount or proxy route claims, and returns the mounts with absolute folders
+func resolveMounts(mounts []config.Mount, proxies []config.ProxyRoute) ([]config.Mount, error) {
+	seen := make(map[string]string)
+	for _, route := range proxies {
+		seen[strings.TrimSuffix(route.Prefix, "/")] = "a proxy route"
+	}
+
+	var resolved []config.Mount
+	for _, mount := range mounts {
+		if !strings.HasPrefix(mount.Prefix, "/") {
+			return nil, fmt.Errorf("mount prefix %q must start with /", mount.Prefix)
+		}
+		prefix := strings.TrimSuffix(mount.Prefix, "/")
+		if prefix == "" {
+			return nil, fmt.Errorf("mount prefix / is already served by the web folder")
+		}
+		if owner, ok := seen[prefix]; ok {
+			return nil, fmt.Errorf("mount prefix %s is already used by %s", mount.Prefix, owner)
+		}
+		seen[prefix] = "another mount"
+
+		folder, err := filepath.Abs(mount.Folder)
+		if err != nil {
+			return nil, fmt.Errorf("error converting to absolute path: %v", err)
+		}
+		if info, err := os.Stat(folder); err != nil || !info.IsDir() {
+			if err != nil {
+				return nil, fmt.Errorf("folder of mount %s does not exist: %v", prefix, err)
+			}
+			return nil, fmt.Errorf("folder of mount %s is not a directory: %s", prefix, folder)
+		}
+
+		mount.Prefix = prefix
+		mount.Folder = folder
+		if fallback := mount.SPAFallbackFile(); fallback != "" {
+			if info, err := os.Stat(filepath.Join(folder, filepath.FromSlash(fallback))); err != nil || info.IsDir() {
+				return nil, fmt.Errorf("SPA fallback file %s not found in folder of mount %s", fallback, prefix)
+			}
+		}
+		resolved = append(resolved, mount)
+	}
+	return resolved, nil
+}
+
+// mountHandler serves a folder handler below a URL prefix. The prefix itself
+// is redirected to the prefix with a trailing slash, so relative links in the
+// folder resolve below it.
+func mountHandler(prefix string, files http.Handler) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	stripped := http.StripPrefix(prefix, files)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == prefix {
+			target := prefix + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		stripped.ServeHTTP(w, r)
+	})
+}
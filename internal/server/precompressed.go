@@ -0,0 +1,77 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// sidecars are the precompressed variants looked up next to a file, in order
+// of preference
+var sidecars = []struct {
+	encoding string
+	suffix   string
+}{
+	{encodingBrotli, ".br"},
+	{encodingGzip, ".gz"},
+}
+
+// servePrecompressed serves a precompressed sidecar such as app.js.br or
+// app.js.gz in place of app.js when one exists and the client accepts its
+// encoding. It reports whether the request was handled; otherwise the plain
+// file should be served.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, webFolder string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	name := filepath.Join(webFolder, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+	if info, err := os.Stat(name); err != nil || info.IsDir() {
+		return false
+	}
+
+	accepted := acceptedEncodings(r.Header.Get("Accept-Encoding"))
+	hasSidecar := false
+	for _, sidecar := range sidecars {
+		file, err := os.Open(name + sidecar.suffix)
+		if err != nil {
+			continue
+		}
+
+		info, err := file.Stat()
+		if err != nil || info.IsDir() {
+			file.Close()
+			continue
+		}
+		hasSidecar = true
+
+		if encodingQuality(accepted, sidecar.encoding) <= 0 {
+			file.Close()
+			continue
+		}
+		defer file.Close()
+
+		// Describe the original file, not the compressed sidecar
+		header := w.Header()
+		if header.Get("Content-Type") == "" {
+			contentType := mime.TypeByExtension(filepath.Ext(name))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			header.Set("Content-Type", contentType)
+		}
+		header.Set("Content-Encoding", sidecar.encoding)
+		header.Add("Vary", "Accept-Encoding")
+
+		http.ServeContent(w, r, name, info.ModTime(), file)
+		return true
+	}
+
+	// The plain file is one of several representations
+	if hasSidecar {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	return false
+}
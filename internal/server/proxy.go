@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// ParseProxyRoute parses a "PREFIX=URL[,URL...][;option...]" proxy rule such
+// as "/api=http://localhost:3000;strip". Several URLs form a load balanced
+// pool. Options are:
+//
+//	strip                    remove the prefix before forwarding
+//	preserve-host            forward the original Host header
+//	header=Name:Value        set a request header, or remove it if Value is empty
+//	response-header=Name:Value
+//	                         set a response header, or remove it if Value is empty
+//	strategy=NAME            round-robin, least-conn or ip-hash
+//	retries=N                upstreams to try after one can't be reached
+//	health-check=PATH        probe every upstream at PATH, ejecting failing ones
+//	health-interval=DURATION time between health checks
+//	health-timeout=DURATION  time allowed for a health check
+func ParseProxyRoute(value string) (config.ProxyRoute, error) {
+	var route config.ProxyRoute
+
+	parts := strings.Split(value, ";")
+	prefix, upstream, ok := strings.Cut(parts[0], "=")
+	if !ok || prefix == "" || upstream == "" {
+		return route, fmt.Errorf("invalid proxy route %q (expected PREFIX=URL)", value)
+	}
+	route.Prefix = strings.TrimSpace(prefix)
+	if upstreams := strings.Split(upstream, ","); len(upstreams) > 1 {
+		for _, u := range upstreams {
+			if u = strings.TrimSpace(u); u != "" {
+				route.Upstreams = append(route.Upstreams, u)
+			}
+		}
+	} else {
+		route.Upstream = strings.TrimSpace(upstream)
+	}
+
+	for _, option := range parts[1:] {
+		option = strings.TrimSpace(option)
+		name, arg, _ := strings.Cut(option, "=")
+		switch name {
+		case "":
+		case "strip":
+			route.StripPrefix = true
+		case "preserve-host":
+			route.PreserveHost = true
+		case "header", "response-header":
+			header, headerValue, ok := strings.Cut(arg, ":")
+			if !ok || strings.TrimSpace(header) == "" {
+				return route, fmt.Errorf("invalid proxy header %q (expected Name:Value)", arg)
+			}
+			headers := &route.RequestHeaders
+			if name == "response-header" {
+				headers = &route.ResponseHeaders
+			}
+			if *headers == nil {
+				*headers = make(map[string]string)
+			}
+			(*headers)[http.CanonicalHeaderKey(strings.TrimSpace(header))] = strings.TrimSpace(headerValue)
+		case "strategy":
+			route.Strategy = arg
+		case "retries":
+			retries, err := strconv.Atoi(arg)
+			if err != nil || retries < 0 {
+				return route, fmt.Errorf("invalid proxy retries %q", arg)
+			}
+			route.Retries = retries
+		case "health-check", "health-interval", "health-timeout":
+			if route.HealthCheck == nil {
+				route.HealthCheck = &config.UpstreamHealthCheck{}
+			}
+			if name == "health-check" {
+				route.HealthCheck.Path = arg
+				break
+			}
+			d, err := time.ParseDuration(arg)
+			if err != nil || d <= 0 {
+				return route, fmt.Errorf("invalid proxy %s %q", name, arg)
+			}
+			if name == "health-interval" {
+				route.HealthCheck.Interval = config.Duration(d)
+			} else {
+				route.HealthCheck.Timeout = config.Duration(d)
+			}
+		default:
+			return route, fmt.Errorf("unknown proxy option %q", option)
+		}
+	}
+	return route, nil
+}
+
+// ValidateProxies checks that every route has a usable prefix and upstream
+// and that no two routes share a prefix
+func ValidateProxies(routes []config.ProxyRoute) error {
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Prefix, "/") {
+			return fmt.Errorf("proxy prefix %q must start with /", route.Prefix)
+		}
+		prefix := strings.TrimSuffix(route.Prefix, "/")
+		if seen[prefix] {
+			return fmt.Errorf("duplicate proxy prefix %s", route.Prefix)
+		}
+		seen[prefix] = true
+
+		targets := route.Targets()
+		if len(targets) == 0 {
+			return fmt.Errorf("proxy route %s has no upstream", route.Prefix)
+		}
+		for _, upstream := range targets {
+			if _, err := parseUpstream(upstream); err != nil {
+				return err
+			}
+		}
+		if err := ValidateStrategy(route.Strategy); err != nil {
+			return err
+		}
+		if route.Retries < 0 {
+			return fmt.Errorf("proxy retries must not be negative")
+		}
+		if check := route.HealthCheck; check != nil && check.Path != "" && !strings.HasPrefix(check.Path, "/") {
+			return fmt.Errorf("health check path %q must start with /", check.Path)
+		}
+	}
+	return nil
+}
+
+// parseUpstream parses an upstream URL, which must be absolute http, https,
+// ws or wss
+func parseUpstream(upstream string) (*url.URL, error) {
+	target, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %v", upstream, err)
+	}
+	switch target.Scheme {
+	case "http", "https":
+	case "ws":
+		target.Scheme = "http"
+	case "wss":
+		target.Scheme = "https"
+	default:
+		return nil, fmt.Errorf("invalid upstream %q (expected an http or https URL)", upstream)
+	}
+	if target.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q (missing host)", upstream)
+	}
+	return target, nil
+}
+
+// proxyPatterns returns the mux patterns a route prefix is mounted on: the
+// prefix itself and everything below it
+func proxyPatterns(prefix string) []string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return []string{"/"}
+	}
+	return []string{prefix, prefix + "/"}
+}
+
+// proxyAttemptKey is the request context key of the current proxy attempt
+type proxyAttemptKey struct{}
+
+// proxyAttempt is one try at forwarding a request to an upstream
+type proxyAttempt struct {
+	upstream *upstream
+	dialErr  error
+}
+
+// proxyHandler forwards requests under a route's prefix to an upstream of
+// its pool. X-Forwarded-For, -Host and -Proto are set for the upstream, and
+// WebSocket upgrades are passed through. Requests that fail to connect are
+// retried on another upstream up to the route's retry count.
+func proxyHandler(name string, p *pool) http.Handler {
+	route := p.route
+	prefix := strings.TrimSuffix(route.Prefix, "/")
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			if route.StripPrefix {
+				stripPrefix(r.Out.URL, prefix)
+			}
+			r.SetURL(r.In.Context().Value(proxyAttemptKey{}).(*proxyAttempt).upstream.target)
+			r.SetXForwarded()
+			if route.PreserveHost {
+				r.Out.Host = r.In.Host
+			}
+			setHeaders(r.Out.Header, route.RequestHeaders)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			setHeaders(resp.Header, route.ResponseHeaders)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			attempt := r.Context().Value(proxyAttemptKey{}).(*proxyAttempt)
+			// Nothing has been written when the upstream can't be reached, so
+			// the response is left to the retry loop
+			if isDialError(err) {
+				attempt.dialErr = err
+				return
+			}
+			fmt.Printf("Error proxying %s %s for %s to %s: %v\n", r.Method, r.URL.Path, name, attempt.upstream.raw, err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A request body can only be sent once
+		retries := route.Retries
+		if r.Body != nil && r.Body != http.NoBody {
+			retries = 0
+		}
+
+		tried := make(map[*upstream]bool)
+		for i := 0; i <= retries; i++ {
+			u := p.pick(r, tried)
+			if u == nil {
+				break
+			}
+			tried[u] = true
+
+			attempt := &proxyAttempt{upstream: u}
+			u.active.Add(1)
+			proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), proxyAttemptKey{}, attempt)))
+			u.active.Add(-1)
+			if attempt.dialErr == nil {
+				return
+			}
+			fmt.Printf("Error proxying %s %s for %s to %s: %v\n", r.Method, r.URL.Path, name, u.raw, attempt.dialErr)
+		}
+
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	})
+}
+
+// isDialError reports whether err means the upstream could not be reached, in
+// which case the request never left this server
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// stripPrefix removes a path prefix from a URL, leaving at least "/"
+func stripPrefix(u *url.URL, prefix string) {
+	u.Path = strings.TrimPrefix(u.Path, prefix)
+	if u.RawPath != "" {
+		u.RawPath = strings.TrimPrefix(u.RawPath, prefix)
+	}
+	if !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+		if u.RawPath != "" {
+			u.RawPath = "/" + u.RawPath
+		}
+	}
+}
+
+// setHeaders applies header rewrites, where an empty value removes the header
+func setHeaders(header http.Header, rewrites map[string]string) {
+	for name, value := range rewrites {
+		if value == "" {
+			header.Del(name)
+		} else {
+			header.Set(name, value)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// Ways of telling the clients of a rate limit apart
+const (
+	RateLimitByIP   = "ip"
+	RateLimitByUser = "user"
+)
+
+// rateLimitSweepInterval is how often the buckets of clients that stopped
+// sending requests are dropped
+const rateLimitSweepInterval = time.Minute
+
+// ValidateRateLimit checks that the rate limit settings are usable
+func ValidateRateLimit(cfg *config.RateLimitConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.Rate < 0 || math.IsInf(cfg.Rate, 0) || math.IsNaN(cfg.Rate) {
+		return fmt.Errorf("invalid rate limit %v (expected requests per second)", cfg.Rate)
+	}
+	if cfg.Burst < 0 || cfg.MaxRequests < 0 {
+		return fmt.Errorf("rate limit burst and concurrent request limit must not be negative")
+	}
+	if cfg.Rate == 0 && cfg.MaxRequests == 0 {
+		return fmt.Errorf("rate limit needs a rate or a concurrent request limit")
+	}
+	if cfg.Rate == 0 && (cfg.Burst != 0 || cfg.By != "") {
+		return fmt.Errorf("rate limit burst and key require a rate")
+	}
+	switch cfg.By {
+	case "", RateLimitByIP, RateLimitByUser:
+		return nil
+	}
+	return fmt.Errorf("invalid rate limit key %q (expected %s or %s)", cfg.By, RateLimitByIP, RateLimitByUser)
+}
+
+// bucket holds the requests a client may still send right away
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per client. Buckets refill at rate tokens
+// per second up to burst, and every request takes one.
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token from the bucket of a client, or returns how long the
+// client has to wait for one
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key, now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, l.wait(b)
+}
+
+// ready reports whether the bucket of a client holds a token, without taking
+// it, or returns how long the client has to wait for one
+func (l *rateLimiter) ready(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key, now)
+	if b.tokens >= 1 {
+		return true, 0
+	}
+	return false, l.wait(b)
+}
+
+// refill returns the bucket of a client topped up to now. The caller holds
+// the lock.
+func (l *rateLimiter) refill(key string, now time.Time) *bucket {
+
+	// A bucket that filled up again is the same as a new one
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.lastSweep = now
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	return b
+}
+
+// wait returns how long a bucket takes to refill to one token
+func (l *rateLimiter) wait(b *bucket) time.Duration {
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// withRateLimit answers 429 Too Many Requests to clients that send requests
+// faster than the instance's rate limit allows, and to every client while the
+// instance already serves as many requests at once as its limit allows.
+// The exempt paths, such as health checks, are never limited.
+func (s *Server) withRateLimit(auth *authenticator, exempt []string, next http.Handler) http.Handler {
+	cfg := s.config.RateLimit
+	if cfg == nil {
+		return next
+	}
+
+	st := s.stats
+	trusted := trustedProxies(s.config.IPFilter)
+	var limiter *rateLimiter
+	if cfg.Rate > 0 {
+		limiter = newRateLimiter(cfg.Rate, cfg.Burst)
+	}
+	var active atomic.Int64
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range exempt {
+			if r.URL.Path == p {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if limiter != nil {
+			now := time.Now()
+			address := clientAddress(trusted, r)
+
+			key := address
+			if cfg.By == RateLimitByUser && auth != nil {
+				// An address that is out of requests, say from guessing
+				// passwords, is turned away before its credentials cost a
+				// bcrypt run. They are checked once, here, for withAuth as
+				// well.
+				if ok, wait := limiter.ready(address, now); !ok {
+					st.rateLimited.Add(1)
+					tooManyRequests(w, wait)
+					return
+				}
+				var c credentials
+				c, r = auth.check(r)
+				if identity, ok := c.identity(); ok {
+					key = identity
+				}
+			}
+			if ok, wait := limiter.allow(key, now); !ok {
+				st.rateLimited.Add(1)
+				tooManyRequests(w, wait)
+				return
+			}
+		}
+
+		if cfg.MaxRequests > 0 {
+			if active.Add(1) > int64(cfg.MaxRequests) {
+				active.Add(-1)
+				st.concurrencyLimited.Add(1)
+				tooManyRequests(w, time.Second)
+				return
+			}
+			defer active.Add(-1)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tooManyRequests answers 429 with the whole seconds to wait before retrying
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+}
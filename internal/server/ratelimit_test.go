@@ -0,0 +1,284 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter(2, 3)
+	now := time.Now()
+
+	// The burst is available right away, then the client waits for a token
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := l.allow("a", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("request over the burst: %v, wait %v, want a refusal and 500ms", ok, wait)
+	}
+
+	// Other clients have buckets of their own
+	if ok, _ := l.allow("b", now); !ok {
+		t.Error("another client was refused")
+	}
+
+	// A token comes back every 1/rate seconds
+	if ok, wait := l.allow("a", now.Add(200*time.Millisecond)); ok || wait != 300*time.Millisecond {
+		t.Errorf("request before the refill: %v, wait %v, want a refusal and 300ms", ok, wait)
+	}
+	if ok, _ := l.allow("a", now.Add(500*time.Millisecond)); !ok {
+		t.Error("request after the refill refused")
+	}
+	if ok, _ := l.allow("a", now.Add(500*time.Millisecond)); ok {
+		t.Error("refill granted more than one token")
+	}
+
+	// A quiet client gets no more than the burst back
+	later := now.Add(10 * time.Second)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a", later); !ok {
+			t.Fatalf("request %d of the refilled burst refused", i+1)
+		}
+	}
+	if ok, _ := l.allow("a", later); ok {
+		t.Error("bucket refilled beyond the burst")
+	}
+}
+
+func TestRateLimiterDefaultBurst(t *testing.T) {
+	l := newRateLimiter(2.5, 0)
+	now := time.Now()
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.allow("a", now); ok {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("default burst allowed %d requests, want 3", allowed)
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := newRateLimiter(1, 5)
+	now := time.Now()
+	l.allow("idle", now)
+	for i := 0; i < 5; i++ {
+		l.allow("busy", now)
+	}
+
+	// After a minute, only the bucket that filled up again is dropped
+	l.allow("busy", now.Add(rateLimitSweepInterval))
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("full bucket was kept")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("bucket in use was dropped")
+	}
+}
+
+func TestTooManyRequests(t *testing.T) {
+	tests := []struct {
+		wait       time.Duration
+		retryAfter string
+	}{
+		{0, "1"},
+		{200 * time.Millisecond, "1"},
+		{time.Second, "1"},
+		{1500 * time.Millisecond, "2"},
+		{90 * time.Second, "90"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		tooManyRequests(rec, tt.wait)
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != tt.retryAfter {
+			t.Errorf("wait %v: status %d, Retry-After %q, want 429 and %s", tt.wait, rec.Code, rec.Header().Get("Retry-After"), tt.retryAfter)
+		}
+	}
+}
+
+func TestRateLimitByIP(t *testing.T) {
+	s := NewServer(config.InstanceConfig{
+		Name:      "site",
+		RateLimit: &config.RateLimitConfig{Rate: 1, Burst: 2},
+		IPFilter:  &config.IPFilterConfig{TrustedProxies: []string{"10.0.0.1"}},
+	})
+	handler := s.withRateLimit(nil, []string{DefaultLivenessPath}, &passedOn{})
+
+	send := func(target, remoteAddr, forwarded string) int {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.RemoteAddr = remoteAddr
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := send("/", "192.0.2.1:1000", ""); code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, code)
+		}
+	}
+	if code := send("/", "192.0.2.1:2000", ""); code != http.StatusTooManyRequests {
+		t.Errorf("request over the limit from another port: status %d, want 429", code)
+	}
+	if code := send(DefaultLivenessPath, "192.0.2.1:1000", ""); code != http.StatusOK {
+		t.Errorf("exempt path: status %d, want 200", code)
+	}
+	if code := send("/", "10.0.0.1:1000", "198.51.100.7"); code != http.StatusOK {
+		t.Errorf("client behind the proxy: status %d, want 200", code)
+	}
+	if code := send("/", "10.0.0.1:1000", "192.0.2.1"); code != http.StatusTooManyRequests {
+		t.Errorf("limited client behind the proxy: status %d, want 429", code)
+	}
+	if got := s.stats.rateLimited.Load(); got != 2 {
+		t.Errorf("counted %d limited requests, want 2", got)
+	}
+}
+
+func TestMaxRequests(t *testing.T) {
+	s := NewServer(config.InstanceConfig{
+		Name:      "site",
+		RateLimit: &config.RateLimitConfig{MaxRequests: 1},
+	})
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := s.withRateLimit(nil, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+		close(done)
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("request over the concurrent request limit: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if got := s.stats.concurrencyLimited.Load(); got != 1 {
+		t.Errorf("counted %d limited connections, want 1", got)
+	}
+
+	close(release)
+	<-done
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("request after the slow one finished: status %d, want 200", rec.Code)
+	}
+}
+
+// userRateLimitServer returns a server that lets each user of its htpasswd
+// file send a single request. Both alice and bob use the password "secret".
+func userRateLimitServer(t *testing.T) (*Server, *authenticator) {
+	name := filepath.Join(t.TempDir(), "users.htpasswd")
+	data := "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\nbob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"
+	if err := os.WriteFile(name, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	instance := config.InstanceConfig{
+		Name:      "site",
+		BasicAuth: &config.BasicAuthConfig{File: name},
+		RateLimit: &config.RateLimitConfig{Rate: 0.001, Burst: 1, By: RateLimitByUser},
+	}
+	return NewServer(instance), newAuthenticator(instance)
+}
+
+func TestRateLimitByUser(t *testing.T) {
+	s, auth := userRateLimitServer(t)
+	handler := s.withRateLimit(auth, nil, withAuth(auth, nil, &passedOn{}))
+
+	send := func(remoteAddr, user, password string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		if user != "" {
+			r.SetBasicAuth(user, password)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		user       string
+		password   string
+		status     int
+	}{
+		{"alice", "192.0.2.1:1000", "alice", "secret", http.StatusOK},
+		{"alice again", "192.0.2.2:1000", "alice", "secret", http.StatusTooManyRequests},
+		{"bob from alice's address", "192.0.2.1:1000", "bob", "secret", http.StatusOK},
+		{"wrong password", "192.0.2.3:1000", "alice", "wrong", http.StatusUnauthorized},
+		{"wrong password again", "192.0.2.3:1000", "bob", "wrong", http.StatusTooManyRequests},
+		{"anonymous from that address", "192.0.2.3:1000", "", "", http.StatusTooManyRequests},
+		{"anonymous from elsewhere", "192.0.2.4:1000", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if code := send(tt.remoteAddr, tt.user, tt.password); code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, code, tt.status)
+		}
+	}
+}
+
+func TestCredentialsCheckedOnce(t *testing.T) {
+	_, auth := userRateLimitServer(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("alice", "secret")
+	c, checked := auth.check(r)
+	if !c.hasUser || c.user != "alice" {
+		t.Fatal("valid credentials were refused")
+	}
+
+	// A second check of the same request doesn't read the users again
+	auth.users = &htpasswdFile{name: filepath.Join(t.TempDir(), "missing")}
+	if c, _ := auth.check(checked); !c.hasUser {
+		t.Error("credentials were checked again")
+	}
+	if c, _ := auth.check(r); c.hasUser {
+		t.Error("credentials of an unchecked request weren't checked")
+	}
+}
+
+func TestRateLimitByUserTurnsAwayBeforeChecking(t *testing.T) {
+	s, auth := userRateLimitServer(t)
+	handler := s.withRateLimit(auth, nil, withAuth(auth, nil, &passedOn{}))
+	send := func(user, password string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(user, password)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	// A wrong password uses up the address, and further requests from it
+	// don't read the users at all
+	if code := send("alice", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: status %d, want 401", code)
+	}
+	auth.users = &htpasswdFile{name: auth.users.name}
+	if code := send("bob", "secret"); code != http.StatusTooManyRequests {
+		t.Errorf("request from an address out of requests: status %d, want 429", code)
+	}
+	if !auth.users.checked.IsZero() {
+		t.Error("credentials were checked for an address out of requests")
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// ParseRule parses a "PATTERN TARGET [STATUS] [option...]" rule such as
+// "^/old/(.*)$ /new/$1 301". PATTERN is a regular expression matched against
+// the request path and TARGET may refer to its captures as $1 or ${name}.
+// Without a status, or with 200, the request is rewritten internally;
+// otherwise it is redirected with the given 3xx status. Options are:
+//
+//	method=GET,HEAD        only apply to these methods
+//	header=Name:REGEX      only apply when the header matches REGEX, or is
+//	                       present at all when REGEX is empty
+func ParseRule(value string) (config.RewriteRule, error) {
+	var rule config.RewriteRule
+
+	fields := strings.Fields(value)
+	if len(fields) < 2 {
+		return rule, fmt.Errorf("invalid rule %q (expected PATTERN TARGET [STATUS])", value)
+	}
+	rule.Match = fields[0]
+	rule.Target = fields[1]
+
+	for _, field := range fields[2:] {
+		if status, err := strconv.Atoi(field); err == nil {
+			if status != http.StatusOK {
+				rule.Status = status
+			}
+			continue
+		}
+
+		name, arg, _ := strings.Cut(field, "=")
+		switch name {
+		case "method":
+			for _, method := range strings.Split(arg, ",") {
+				if method = strings.TrimSpace(method); method != "" {
+					rule.Methods = append(rule.Methods, strings.ToUpper(method))
+				}
+			}
+		case "header":
+			header, pattern, ok := strings.Cut(arg, ":")
+			if !ok || strings.TrimSpace(header) == "" {
+				return rule, fmt.Errorf("invalid rule header %q (expected Name:REGEX)", arg)
+			}
+			if rule.Headers == nil {
+				rule.Headers = make(map[string]string)
+			}
+			rule.Headers[http.CanonicalHeaderKey(strings.TrimSpace(header))] = pattern
+		default:
+			return rule, fmt.Errorf("unknown rule option %q", field)
+		}
+	}
+	return rule, nil
+}
+
+// ValidateRules checks that every rule compiles and has a usable target and
+// status
+func ValidateRules(rules []config.RewriteRule) error {
+	for _, rule := range rules {
+		if _, err := compileRule(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rule is a rewrite rule with its regular expressions compiled
+type rule struct {
+	config.RewriteRule
+	match   *regexp.Regexp
+	methods map[string]bool
+	headers map[string]*regexp.Regexp
+}
+
+func compileRule(cfg config.RewriteRule) (*rule, error) {
+	match, err := regexp.Compile(cfg.Match)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule pattern %q: %v", cfg.Match, err)
+	}
+
+	// A target may also start with a capture, which holds a path
+	local := strings.HasPrefix(cfg.Target, "/") || strings.HasPrefix(cfg.Target, "$")
+	switch cfg.Status {
+	case 0:
+		if !local {
+			return nil, fmt.Errorf("rewrite target %q must start with / or a capture", cfg.Target)
+		}
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		if !local && !isAbsoluteURL(cfg.Target) {
+			return nil, fmt.Errorf("redirect target %q must start with /, a capture, or be an http or https URL", cfg.Target)
+		}
+	default:
+		return nil, fmt.Errorf("invalid rule status %d (expected 200 or 301, 302, 303, 307, 308)", cfg.Status)
+	}
+
+	r := &rule{RewriteRule: cfg, match: match}
+	if len(cfg.Methods) > 0 {
+		r.methods = make(map[string]bool, len(cfg.Methods))
+		for _, method := range cfg.Methods {
+			r.methods[strings.ToUpper(method)] = true
+		}
+	}
+	for header, pattern := range cfg.Headers {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule header pattern %q: %v", pattern, err)
+		}
+		if r.headers == nil {
+			r.headers = make(map[string]*regexp.Regexp)
+		}
+		r.headers[header] = re
+	}
+	return r, nil
+}
+
+// apply returns the expanded target of the rule for a request, or false when
+// the rule doesn't match it
+func (r *rule) apply(req *http.Request) (string, bool) {
+	if r.methods != nil && !r.methods[req.Method] {
+		return "", false
+	}
+	for header, re := range r.headers {
+		values, ok := req.Header[header]
+		if !ok {
+			return "", false
+		}
+		if !re.MatchString(strings.Join(values, ", ")) {
+			return "", false
+		}
+	}
+
+	submatches := r.match.FindStringSubmatchIndex(req.URL.Path)
+	if submatches == nil {
+		return "", false
+	}
+	target := string(r.match.ExpandString(nil, r.Target, req.URL.Path, submatches))
+	if !isAbsoluteURL(r.Target) && !strings.HasPrefix(target, "/") {
+		// A capture holds a path, even when it reads as a URL
+		target = "/" + target
+	}
+	return target, true
+}
+
+// withRewrites applies the first rule matching each request: a redirect is
+// answered right away, and a rewrite passes the request on with the new path.
+// The query string of the request is kept unless the target has its own.
+func withRewrites(cfgs []config.RewriteRule, next http.Handler) http.Handler {
+	var rules []*rule
+	for _, cfg := range cfgs {
+		r, err := compileRule(cfg)
+		if err != nil {
+			fmt.Printf("Warning: Skipping rule %s: %v\n", cfg.Match, err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	if len(rules) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for _, r := range rules {
+			target, ok := r.apply(req)
+			if !ok {
+				continue
+			}
+
+			if r.Status != 0 {
+				http.Redirect(w, req, redirectLocation(target, req.URL.RawQuery), r.Status)
+				return
+			}
+
+			next.ServeHTTP(w, rewriteRequest(req, target))
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// redirectLocation escapes the path of a local redirect target and carries
+// the request's query string over when the target has none
+func redirectLocation(target, rawQuery string) string {
+	if isAbsoluteURL(target) {
+		if !strings.Contains(target, "?") && rawQuery != "" {
+			target += "?" + rawQuery
+		}
+		return target
+	}
+
+	// Browsers read a path starting with // as another host, so a path filled
+	// in from the request can't send clients off the site
+	path, query, hasQuery := strings.Cut(target, "?")
+	path = "/" + strings.TrimLeft(path, "/")
+	location := (&url.URL{Path: path}).EscapedPath()
+	if hasQuery {
+		location += "?" + query
+	} else if rawQuery != "" {
+		location += "?" + rawQuery
+	}
+	return location
+}
+
+// isAbsoluteURL reports whether a target is an http or https URL
+func isAbsoluteURL(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+func TestRewriteRedirects(t *testing.T) {
+	tests := []struct {
+		rule     string
+		target   string
+		location string
+	}{
+		{"^/old/(.*)$ /new/$1 301", "/old/page?x=1", "/new/page?x=1"},
+		{"^/old/(.*)$ /new/$1?y=2 301", "/old/page?x=1", "/new/page?y=2"},
+		{"^/old/(.*)$ https://example.com/$1 302", "/old/page", "https://example.com/page"},
+		{"^/docs$ /docs/ 301", "/docs", "/docs/"},
+
+		// Captures can't turn a local target into another host
+		{"^/old/(.*)$ /$1 301", "/old//evil.example/x", "/evil.example/x"},
+		{"^/old/(.*)$ /$1 301", "/old////evil.example", "/evil.example"},
+		{"^/old/(.*)$ $1 301", "/old//evil.example/x", "/evil.example/x"},
+		{"^/old/(.*)$ $1 301", "/old/https://evil.example/x", "/https:/evil.example/x"},
+		{"^/old/(.*)$ /$1 301", "/old/%5Cevil.example", "/%5Cevil.example"},
+	}
+
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.rule)
+		if err != nil {
+			t.Fatalf("ParseRule(%q): %v", tt.rule, err)
+		}
+		handler := withRewrites([]config.RewriteRule{rule}, http.NotFoundHandler())
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		if rec.Code != rule.Status {
+			t.Errorf("%q for %s: status %d, want %d", tt.rule, tt.target, rec.Code, rule.Status)
+		}
+		if got := rec.Header().Get("Location"); got != tt.location {
+			t.Errorf("%q for %s: Location %q, want %q", tt.rule, tt.target, got, tt.location)
+		}
+	}
+}
+
+func TestRewriteKeepsLocalTargets(t *testing.T) {
+	rule, err := ParseRule("^/app/(.*)$ $1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var served string
+	handler := withRewrites([]config.RewriteRule{rule}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r.URL.Path
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/app/https://evil.example", nil))
+
+	if served != "/https://evil.example" {
+		t.Errorf("rewritten to %q, want a local path", served)
+	}
+}
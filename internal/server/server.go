@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -11,17 +12,41 @@ import (
 	"sync"
 	"syscall"
 
+	"github.com/mguptahub/nanoHttp/internal/ca"
 	"github.com/mguptahub/nanoHttp/internal/config"
+	"github.com/mguptahub/nanoHttp/internal/supervisor"
 )
 
 // Instance represents a server instance configuration
 type Instance struct {
-	Name            string `json:"name"`
-	Port            int    `json:"port"`
-	WebFolder       string `json:"web_folder"`
-	AllowDirListing bool   `json:"allow_dir_listing"`
-	IsRunning       bool   `json:"is_running"`
-	PID             int    `json:"pid,omitempty"`
+	Name            string                    `json:"name"`
+	Port            int                       `json:"port"`
+	Hosts           []string                  `json:"hosts,omitempty"`
+	DefaultHost     bool                      `json:"default_host,omitempty"`
+	WebFolder       string                    `json:"web_folder"`
+	AllowDirListing bool                      `json:"allow_dir_listing"`
+	SPA             bool                      `json:"spa,omitempty"`
+	SPAFallback     string                    `json:"spa_fallback,omitempty"`
+	Mounts          []config.Mount            `json:"mounts,omitempty"`
+	Rules           []config.RewriteRule      `json:"rules,omitempty"`
+	ErrorPages      map[int]string            `json:"error_pages,omitempty"`
+	Compression     *config.CompressionConfig `json:"compression,omitempty"`
+	AccessLog       *config.AccessLogConfig   `json:"access_log,omitempty"`
+	Logs            *config.LogConfig         `json:"logs,omitempty"`
+	Metrics         *config.MetricsConfig     `json:"metrics,omitempty"`
+	Health          *config.HealthConfig      `json:"health,omitempty"`
+	Proxies         []config.ProxyRoute       `json:"proxies,omitempty"`
+	IPFilter        *config.IPFilterConfig    `json:"ip_filter,omitempty"`
+	RateLimit       *config.RateLimitConfig   `json:"rate_limit,omitempty"`
+	BasicAuth       *config.BasicAuthConfig   `json:"basic_auth,omitempty"`
+	TokenAuth       *config.TokenAuthConfig   `json:"token_auth,omitempty"`
+	SignedURLs      *config.SignedURLConfig   `json:"signed_urls,omitempty"`
+	TLS             *config.TLSConfig         `json:"tls,omitempty"`
+	Restart         *config.RestartPolicy     `json:"restart,omitempty"`
+	IsRunning       bool                      `json:"is_running"`
+	PID             int                       `json:"pid,omitempty"`
+	Restarts        int                       `json:"restarts,omitempty"`
+	LastExit        string                    `json:"last_exit,omitempty"`
 }
 
 // Manager manages multiple server instances
@@ -77,11 +102,153 @@ func (m *Manager) AddInstance(instance *Instance) error {
 		return fmt.Errorf("specified path is not a directory: %s", absWebFolder)
 	}
 
+	// Make sure the SPA fallback file exists inside the web folder
+	if instance.SPA {
+		fallback := instance.SPAFallback
+		if fallback == "" {
+			fallback = config.DefaultSPAFallback
+		}
+		if info, err := os.Stat(filepath.Join(absWebFolder, filepath.FromSlash(fallback))); err != nil || info.IsDir() {
+			return fmt.Errorf("SPA fallback file %s not found in web folder", fallback)
+		}
+	}
+
+	if err := validateErrorPages(absWebFolder, instance.ErrorPages); err != nil {
+		return err
+	}
+
+	if err := ValidateCompression(instance.Compression); err != nil {
+		return err
+	}
+
+	if err := ValidateAccessLog(instance.AccessLog); err != nil {
+		return err
+	}
+
+	if err := ValidateMetrics(instance.Port, instance.Metrics); err != nil {
+		return err
+	}
+
+	if err := ValidateHealth(instance.Health, instance.Metrics); err != nil {
+		return err
+	}
+
+	if err := ValidateProxies(instance.Proxies); err != nil {
+		return err
+	}
+
+	mounts, err := resolveMounts(instance.Mounts, instance.Proxies)
+	if err != nil {
+		return err
+	}
+
+	if err := ValidateRules(instance.Rules); err != nil {
+		return err
+	}
+
+	if err := ValidateIPFilter(instance.IPFilter); err != nil {
+		return err
+	}
+
+	if err := ValidateRateLimit(instance.RateLimit); err != nil {
+		return err
+	}
+
+	hosts, err := normalizeHosts(instance.Hosts)
+	if err != nil {
+		return err
+	}
+
+	// A port already used by another instance can only be shared between
+	// virtual hosts
+	if err := checkSharedPort(config.InstanceConfig{
+		Name:        instance.Name,
+		Port:        instance.Port,
+		Hosts:       hosts,
+		DefaultHost: instance.DefaultHost,
+		TLS:         instance.TLS,
+	}, m.servers); err != nil {
+		return err
+	}
+
+	// Without a file of its own, the instance gets one in the config
+	// directory that "nanoHttp user" manages
+	basicAuth, err := resolveBasicAuth(instance.BasicAuth, m.htpasswdPath(instance.Name))
+	if err != nil {
+		return err
+	}
+
+	tokenAuth, err := resolveTokenAuth(instance.TokenAuth)
+	if err != nil {
+		return err
+	}
+
+	signedURLs, err := resolveSignedURLs(instance.SignedURLs)
+	if err != nil {
+		return err
+	}
+
+	var accessLog *config.AccessLogConfig
+	if instance.AccessLog != nil {
+		accessLogCopy := *instance.AccessLog
+		if accessLogCopy.File != "" {
+			if accessLogCopy.File, err = filepath.Abs(accessLogCopy.File); err != nil {
+				return fmt.Errorf("error converting to absolute path: %v", err)
+			}
+		}
+		accessLog = &accessLogCopy
+	}
+
+	// Resolve TLS file paths, or issue a certificate from the local CA, and
+	// make sure the certificate can be loaded
+	var tlsConfig *config.TLSConfig
+	if instance.TLS != nil {
+		tlsCopy := *instance.TLS
+		if tlsCopy.Auto {
+			// Cover the virtual hostnames in the issued certificate
+			tlsCopy.Hosts = mergeHosts(tlsCopy.Hosts, hosts)
+			if tlsCopy.CertFile, tlsCopy.KeyFile, err = ca.EnsureLeaf(m.configDir, instance.Name, tlsCopy.Hosts); err != nil {
+				return fmt.Errorf("error issuing local certificate: %v", err)
+			}
+		} else {
+			if tlsCopy.CertFile, err = filepath.Abs(tlsCopy.CertFile); err != nil {
+				return fmt.Errorf("error converting to absolute path: %v", err)
+			}
+			if tlsCopy.KeyFile, err = filepath.Abs(tlsCopy.KeyFile); err != nil {
+				return fmt.Errorf("error converting to absolute path: %v", err)
+			}
+		}
+		if err := ValidateTLS(instance.Name, &tlsCopy); err != nil {
+			return err
+		}
+		tlsConfig = &tlsCopy
+	}
+
 	cfg := config.InstanceConfig{
 		Name:            instance.Name,
 		Port:            instance.Port,
+		Hosts:           hosts,
+		DefaultHost:     instance.DefaultHost,
 		WebFolder:       absWebFolder, // Use absolute path
 		AllowDirListing: instance.AllowDirListing,
+		SPA:             instance.SPA,
+		SPAFallback:     instance.SPAFallback,
+		Mounts:          mounts,
+		Rules:           instance.Rules,
+		ErrorPages:      instance.ErrorPages,
+		Compression:     instance.Compression,
+		AccessLog:       accessLog,
+		Logs:            instance.Logs,
+		Metrics:         instance.Metrics,
+		Health:          instance.Health,
+		Proxies:         instance.Proxies,
+		IPFilter:        instance.IPFilter,
+		RateLimit:       instance.RateLimit,
+		BasicAuth:       basicAuth,
+		TokenAuth:       tokenAuth,
+		SignedURLs:      signedURLs,
+		TLS:             tlsConfig,
+		Restart:         instance.Restart,
 	}
 
 	server := NewServer(cfg)
@@ -107,10 +274,47 @@ func (m *Manager) StartInstance(name string) error {
 		return fmt.Errorf("error getting executable path: %v", err)
 	}
 
-	// Start the server in a new process using the 'start -foreground' command
-	cmd := exec.Command(executable, "start", name, "-foreground")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// A virtual host joins the process already serving its port, which picks
+	// it up when signaled to reload
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("error loading config: %v", err)
+	}
+	if pid, ok := sharedHostPID(cfg, server.config); ok {
+		if err := os.WriteFile(server.getPIDFilePath(), []byte(fmt.Sprintf("%d", pid)), 0644); err != nil {
+			return fmt.Errorf("error writing PID file: %v", err)
+		}
+		server.pid = pid
+		server.isRunning = true
+
+		instance := cfg.Instances[name]
+		instance.IsRunning = true
+		instance.PID = pid
+		instance.Restarts = 0
+		cfg.Instances[name] = instance
+		if err := config.SaveConfig(cfg); err != nil {
+			return err
+		}
+
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			return fmt.Errorf("error finding process: %v", err)
+		}
+		if err := process.Signal(syscall.SIGHUP); err != nil {
+			return fmt.Errorf("error signaling process %d: %v", pid, err)
+		}
+		return nil
+	}
+
+	// Start the server in a new process using the 'start -foreground' command,
+	// or under a supervisor process when the instance has a restart policy
+	mode := "-foreground"
+	if supervisor.NewPolicy(server.config.Restart).Enabled() {
+		mode = "-supervise"
+	}
+	// Its output goes to the instance's log files, since it outlives the
+	// terminal it was started from
+	cmd := exec.Command(executable, "start", name, mode, "-log-output")
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("error starting server process: %v", err)
@@ -125,7 +329,7 @@ func (m *Manager) StartInstance(name string) error {
 	server.isRunning = true
 
 	// Load and update configuration
-	cfg, err := config.LoadConfig()
+	cfg, err = config.LoadConfig()
 	if err != nil {
 		return fmt.Errorf("error loading config: %v", err)
 	}
@@ -133,6 +337,7 @@ func (m *Manager) StartInstance(name string) error {
 	instance := cfg.Instances[name]
 	instance.IsRunning = true
 	instance.PID = cmd.Process.Pid
+	instance.Restarts = 0
 	cfg.Instances[name] = instance
 
 	// Save configuration
@@ -210,27 +415,40 @@ func (m *Manager) StopInstance(name string) error {
 		return fmt.Errorf("error finding process: %v", err)
 	}
 
-	if err := process.Signal(syscall.SIGTERM); err != nil {
-		return fmt.Errorf("error stopping process: %v", err)
-	}
-
-	// Remove PID file
-	if err := server.removePIDFile(); err != nil {
-		return fmt.Errorf("error removing PID file: %v", err)
-	}
-
-	server.isRunning = false
-
 	// Load and update configuration
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		return fmt.Errorf("error loading config: %v", err)
 	}
 
+	// A process that serves other virtual hosts on the same port keeps
+	// running and drops this one when signaled to reload, so the
+	// configuration must be updated first
+	shared := sharesProcess(cfg, name, pid)
 	instance := cfg.Instances[name]
 	instance.IsRunning = false
 	instance.PID = 0
 	cfg.Instances[name] = instance
+	if shared {
+		if err := config.SaveConfig(cfg); err != nil {
+			return err
+		}
+		if err := process.Signal(syscall.SIGHUP); err != nil {
+			return fmt.Errorf("error signaling process %d: %v", pid, err)
+		}
+	} else if err := process.Signal(syscall.SIGTERM); err != nil {
+		return fmt.Errorf("error stopping process: %v", err)
+	}
+
+	// Remove PID file
+	if err := server.removePIDFile(); err != nil {
+		return fmt.Errorf("error removing PID file: %v", err)
+	}
+
+	server.isRunning = false
+	if shared {
+		return nil
+	}
 
 	// Save configuration
 	return config.SaveConfig(cfg)
@@ -254,6 +472,20 @@ func (m *Manager) DeleteInstance(name string) error {
 
 	delete(m.servers, name)
 
+	// Remove the certificate issued for the instance by the local CA
+	if tlsConfig := server.config.TLS; tlsConfig != nil && tlsConfig.Auto {
+		if err := ca.RemoveLeaf(m.configDir, name); err != nil {
+			return fmt.Errorf("error removing certificate: %v", err)
+		}
+	}
+
+	// Remove the htpasswd file managed for the instance
+	if auth := server.config.BasicAuth; auth != nil && auth.File == m.htpasswdPath(name) {
+		if err := os.Remove(auth.File); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing htpasswd file: %v", err)
+		}
+	}
+
 	// Save configuration
 	return m.saveConfig()
 }
@@ -275,35 +507,67 @@ func (m *Manager) ListInstances() []*Instance {
 		instances = append(instances, &Instance{
 			Name:            name,
 			Port:            instance.Port,
+			Hosts:           instance.Hosts,
+			DefaultHost:     instance.DefaultHost,
 			WebFolder:       instance.WebFolder,
 			AllowDirListing: instance.AllowDirListing,
+			SPA:             instance.SPA,
+			SPAFallback:     instance.SPAFallback,
+			Mounts:          instance.Mounts,
+			Rules:           instance.Rules,
+			ErrorPages:      instance.ErrorPages,
+			Compression:     instance.Compression,
+			AccessLog:       instance.AccessLog,
+			Logs:            instance.Logs,
+			Metrics:         instance.Metrics,
+			Health:          instance.Health,
+			Proxies:         instance.Proxies,
+			IPFilter:        instance.IPFilter,
+			RateLimit:       instance.RateLimit,
+			BasicAuth:       instance.BasicAuth,
+			TokenAuth:       instance.TokenAuth,
+			SignedURLs:      instance.SignedURLs,
+			TLS:             instance.TLS,
+			Restart:         instance.Restart,
 			IsRunning:       instance.IsRunning,
 			PID:             instance.PID,
+			Restarts:        instance.Restarts,
+			LastExit:        instance.LastExit,
 		})
 	}
 	return instances
 }
 
-// saveConfig saves the current configuration to disk
+// saveConfig saves the current configuration to disk. The running state and
+// restart history are carried over from the file on disk, since instances may
+// be hosted by another process (a background child, its supervisor or the
+// daemon) that owns that part of the state.
 func (m *Manager) saveConfig() error {
-	configPath := filepath.Join(m.configDir, "config.json")
-	config := struct {
-		Instances map[string]*Instance `json:"instances"`
-	}{
-		Instances: make(map[string]*Instance),
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("error loading config: %v", err)
 	}
 
+	instances := make(map[string]config.InstanceConfig, len(m.servers))
 	for name, server := range m.servers {
-		config.Instances[name] = &Instance{
-			Name:            name,
-			Port:            server.config.Port,
-			WebFolder:       server.config.WebFolder,
-			AllowDirListing: server.config.AllowDirListing,
-			IsRunning:       server.IsRunning(),
+		instance := server.config
+		instance.Name = name
+		instance.IsRunning = false
+		instance.PID = 0
+		instance.Restarts = 0
+		instance.LastExit = ""
+		if existing, ok := cfg.Instances[name]; ok {
+			instance.IsRunning = existing.IsRunning
+			instance.PID = existing.PID
+			instance.Restarts = existing.Restarts
+			instance.LastExit = existing.LastExit
 		}
+		instances[name] = instance
 	}
+	cfg.Instances = instances
 
-	data, err := json.MarshalIndent(config, "", "  ")
+	configPath := filepath.Join(m.configDir, "config.json")
+	data, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
 		return fmt.Errorf("error marshaling config: %v", err)
 	}
@@ -317,18 +581,26 @@ func (m *Manager) saveConfig() error {
 
 // Server represents an HTTP server instance
 type Server struct {
-	config     config.InstanceConfig
-	server     *http.Server
-	mu         sync.Mutex
-	isRunning  bool
-	cancelFunc context.CancelFunc
-	pid        int
+	config         config.InstanceConfig
+	server         *http.Server
+	redirectServer *http.Server
+	metricsServer  *http.Server
+	shared         *sharedListener
+	mu             sync.Mutex
+	isRunning      bool
+	cancelFunc     context.CancelFunc
+	pid            int
+	stats          *stats
+	accessLog      *accessLogger
+	pools          []*pool
+	done           chan error
 }
 
 // NewServer creates a new server instance
 func NewServer(cfg config.InstanceConfig) *Server {
 	return &Server{
 		config: cfg,
+		stats:  newStats(),
 	}
 }
 
@@ -409,11 +681,245 @@ func (s *Server) Stop() error {
 	return nil
 }
 
-// IsRunning returns whether the server is currently running
+// Serve starts the HTTP server inside the current process. It returns once the
+// listener is bound, so port conflicts are reported to the caller; requests are
+// then served in the background until Shutdown is called.
+func (s *Server) Serve() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.server != nil {
+		return fmt.Errorf("server %s is already running", s.config.Name)
+	}
+
+	tlsConfig, err := buildTLSConfig(s.config.Name, s.config.TLS)
+	if err != nil {
+		return err
+	}
+
+	// Virtual hosts join the listener shared by their port once their
+	// handler is ready; other instances bind the port themselves
+	var listener net.Listener
+	if !s.config.VirtualHost() {
+		listener, err = net.Listen("tcp", fmt.Sprintf(":%d", s.config.Port))
+		if err != nil {
+			return fmt.Errorf("error listening on port %d: %v", s.config.Port, err)
+		}
+	}
+
+	// Optionally redirect plain HTTP on a second port to HTTPS
+	var redirectListener, metricsListener net.Listener
+	closeListeners := func() {
+		for _, l := range []net.Listener{listener, redirectListener, metricsListener} {
+			if l != nil {
+				l.Close()
+			}
+		}
+	}
+	if tlsConfig != nil && s.config.TLS.RedirectPort > 0 {
+		redirectListener, err = net.Listen("tcp", fmt.Sprintf(":%d", s.config.TLS.RedirectPort))
+		if err != nil {
+			closeListeners()
+			return fmt.Errorf("error listening on redirect port %d: %v", s.config.TLS.RedirectPort, err)
+		}
+	}
+
+	// Optionally serve metrics on their own port, away from public traffic
+	if s.config.Metrics != nil && s.config.Metrics.Port > 0 {
+		metricsListener, err = net.Listen("tcp", fmt.Sprintf(":%d", s.config.Metrics.Port))
+		if err != nil {
+			closeListeners()
+			return fmt.Errorf("error listening on metrics port %d: %v", s.config.Metrics.Port, err)
+		}
+	}
+
+	accessLog, err := openAccessLog(s.config)
+	if err != nil {
+		closeListeners()
+		return err
+	}
+
+	s.stats = newStats()
+	s.accessLog = accessLog
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	var (
+		httpServer *http.Server
+		shared     *sharedListener
+	)
+	if s.config.VirtualHost() {
+		st := &site{
+			server:    s,
+			hosts:     s.config.Hosts,
+			isDefault: s.config.DefaultHost,
+			handler:   s.createHandler(),
+			done:      done,
+		}
+		if tlsConfig != nil {
+			st.cert = &tlsConfig.Certificates[0]
+		}
+		if shared, err = joinShared(s.config.Port, tlsConfig, st); err != nil {
+			closeListeners()
+			if accessLog != nil {
+				accessLog.Close()
+			}
+			s.accessLog = nil
+			cancel()
+			return err
+		}
+		httpServer = shared.server
+	} else {
+		httpServer = &http.Server{
+			Handler:   s.createHandler(),
+			TLSConfig: tlsConfig,
+			BaseContext: func(l net.Listener) context.Context {
+				return ctx
+			},
+		}
+	}
+
+	// Publish the state of proxy upstreams and keep it current with active
+	// health checks
+	if pools := s.pools; len(pools) > 0 {
+		s.writeUpstreamState(pools)
+		for _, p := range pools {
+			if p.route.HealthCheck != nil {
+				go p.healthCheck(ctx, func() { s.writeUpstreamState(pools) })
+			}
+		}
+	}
+
+	s.server = httpServer
+	s.shared = shared
+	s.cancelFunc = cancel
+	s.done = done
+	s.pid = os.Getpid()
+	s.isRunning = true
+
+	if redirectListener != nil {
+		redirectServer := &http.Server{Handler: redirectToHTTPS(s.config.Port)}
+		s.redirectServer = redirectServer
+		go func() {
+			if err := redirectServer.Serve(redirectListener); err != nil && err != http.ErrServerClosed {
+				fmt.Printf("Error serving HTTPS redirect for %s: %v\n", s.config.Name, err)
+			}
+		}()
+	}
+
+	if metricsListener != nil {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle(metricsPath(s.config.Metrics), s.metricsHandler())
+		metricsServer := &http.Server{Handler: metricsMux}
+		s.metricsServer = metricsServer
+		go func() {
+			if err := metricsServer.Serve(metricsListener); err != nil && err != http.ErrServerClosed {
+				fmt.Printf("Error serving metrics for %s: %v\n", s.config.Name, err)
+			}
+		}()
+	}
+
+	if shared != nil {
+		return nil
+	}
+
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			// The certificate is already loaded into TLSConfig
+			err = httpServer.ServeTLS(listener, "", "")
+		} else {
+			err = httpServer.Serve(listener)
+		}
+		if err == http.ErrServerClosed {
+			err = nil
+		} else if err != nil {
+			fmt.Printf("Error serving %s: %v\n", s.config.Name, err)
+		}
+		s.serverExited(httpServer, done, err)
+	}()
+
+	return nil
+}
+
+// serverExited releases what the server holds once httpServer has stopped
+// serving without a Shutdown, and reports err on done
+func (s *Server) serverExited(httpServer *http.Server, done chan error, err error) {
+	s.mu.Lock()
+	if s.server == httpServer {
+		s.server = nil
+		s.shared = nil
+		s.isRunning = false
+		if s.accessLog != nil {
+			s.accessLog.Close()
+		}
+		s.accessLog = nil
+		s.cancelFunc()
+		s.removeUpstreamState()
+	}
+	s.mu.Unlock()
+
+	done <- err
+}
+
+// Done returns a channel that receives the result of the most recent Serve
+// once the server stops, which is nil after a graceful Shutdown
+func (s *Server) Done() <-chan error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done
+}
+
+// Shutdown gracefully stops a server started with Serve, allowing in-flight
+// requests to complete until ctx expires
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	httpServer := s.server
+	redirectServer := s.redirectServer
+	metricsServer := s.metricsServer
+	shared := s.shared
+	cancel := s.cancelFunc
+	accessLog := s.accessLog
+	s.server = nil
+	s.shared = nil
+	s.redirectServer = nil
+	s.metricsServer = nil
+	s.cancelFunc = nil
+	s.accessLog = nil
+	s.isRunning = false
+	s.mu.Unlock()
+
+	if httpServer == nil {
+		return fmt.Errorf("server %s is not running", s.config.Name)
+	}
+
+	if redirectServer != nil {
+		redirectServer.Shutdown(ctx)
+	}
+	if metricsServer != nil {
+		metricsServer.Shutdown(ctx)
+	}
+	var err error
+	if shared != nil {
+		err = shared.leave(ctx, s)
+	} else {
+		err = httpServer.Shutdown(ctx)
+	}
+	cancel()
+	s.removeUpstreamState()
+	// Close the log only once in-flight requests have been logged
+	if accessLog != nil {
+		accessLog.Close()
+	}
+	return err
+}
+
+// IsRunning returns whether the server is currently running, either inside
+// this process or as a separate process tracked by its PID file
 func (s *Server) IsRunning() bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.checkIfRunning()
+	return s.server != nil || s.checkIfRunning()
 }
 
 // createHandler creates the HTTP handler for the server
@@ -431,6 +937,89 @@ func (s *Server) createHandler() http.Handler {
 		}
 	}
 
+	// The first handler registered for a pattern wins, so built-in endpoints
+	// can't be shadowed by a proxy route
+	registered := make(map[string]bool)
+	handle := func(pattern string, handler http.Handler) {
+		if registered[pattern] {
+			fmt.Printf("Warning: %s is already handled, skipping\n", pattern)
+			return
+		}
+		registered[pattern] = true
+		mux.Handle(pattern, handler)
+	}
+
+	if s.config.Metrics != nil && s.config.Metrics.Port == 0 {
+		handle(metricsPath(s.config.Metrics), s.metricsHandler())
+	}
+	if s.config.Health != nil {
+		liveness, readiness := healthPaths(s.config.Health)
+		handle(liveness, s.healthHandler(webFolder, false))
+		handle(readiness, s.healthHandler(webFolder, true))
+	}
+
+	// Proxy routes take precedence over static files below their prefix
+	s.pools = nil
+	trusted := trustedProxies(s.config.IPFilter)
+	for _, route := range s.config.Proxies {
+		p, err := newPool(route, trusted)
+		if err != nil {
+			fmt.Printf("Warning: Skipping proxy route %s: %v\n", route.Prefix, err)
+			continue
+		}
+		s.pools = append(s.pools, p)
+		proxy := proxyHandler(s.config.Name, p)
+		for _, pattern := range proxyPatterns(route.Prefix) {
+			handle(pattern, proxy)
+		}
+	}
+
+	// Mounted folders serve their prefix unless a proxy route already does
+	for _, mount := range s.config.Mounts {
+		static := s.staticHandler(mount.Folder, mount.AllowDirListing, mount.SPAFallbackFile())
+		handler := withErrorPages(webFolder, s.config.ErrorPages, mountHandler(mount.Prefix, static))
+		for _, pattern := range proxyPatterns(mount.Prefix) {
+			handle(pattern, handler)
+		}
+	}
+
+	if !registered["/"] {
+		static := s.staticHandler(webFolder, s.config.AllowDirListing, s.config.SPAFallbackFile())
+		// _redirects and _headers in the web folder apply to the site itself,
+		// not to built-in endpoints, proxy routes or mounts
+		handle("/", withSiteFiles(webFolder, s.config.AllowDirListing, withErrorPages(webFolder, s.config.ErrorPages, static)))
+	}
+
+	// Rules see every request before it is routed, so a rewrite can target
+	// a proxy route or mount as well as a file
+	handler := withRewrites(s.config.Rules, mux)
+
+	// Health checks are answered to any client without credentials, so
+	// probes keep working, and neither they nor metrics scrapes are throttled
+	var exempt []string
+	if s.config.Health != nil {
+		liveness, readiness := healthPaths(s.config.Health)
+		exempt = append(exempt, liveness, readiness)
+	}
+	unlimited := exempt
+	if s.config.Metrics != nil && s.config.Metrics.Port == 0 {
+		unlimited = append(unlimited[:len(unlimited):len(unlimited)], metricsPath(s.config.Metrics))
+	}
+
+	// Clients are filtered by address first, then throttled, then asked for
+	// credentials
+	auth := newAuthenticator(s.config)
+	handler = withAuth(auth, exempt, handler)
+	handler = s.withRateLimit(auth, unlimited, handler)
+	handler = withIPFilter(s.config.Name, s.config.IPFilter, handler)
+
+	return s.trackStats(withAccessLog(s.accessLog, withCompression(s.config.Compression, handler)))
+}
+
+// staticHandler serves the files of a web folder. Directory listings are only
+// served when allowed, and when an SPA fallback file is given it is served for
+// paths that don't resolve to a real file.
+func (s *Server) staticHandler(webFolder string, allowDirListing bool, spaFallback string) http.Handler {
 	// Create a file server with custom file serving middleware
 	fileServerHandler := http.FileServer(http.Dir(webFolder))
 	fileServerWithContentType := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -477,34 +1066,41 @@ func (s *Server) createHandler() http.Handler {
 			w.Header().Set("Content-Type", "application/gzip")
 
 		}
+
+		// Prefer a precompressed .br or .gz sidecar when the client accepts it
+		if servePrecompressed(w, r, webFolder) {
+			return
+		}
 		fileServerHandler.ServeHTTP(w, r)
 	})
 
-	if s.config.AllowDirListing {
-		mux.Handle("/", fileServerWithContentType)
-	} else {
-		mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Handle index file specially
-			if r.URL.Path == "/" {
-				indexPath := filepath.Join(webFolder, "index.html")
-				if _, err := os.Stat(indexPath); err == nil {
-					w.Header().Set("Content-Type", "text/html")
-					http.ServeFile(w, r, indexPath)
-					return
-				}
-			}
+	if spaFallback != "" {
+		return spaHandler(webFolder, allowDirListing, spaFallback, fileServerWithContentType)
+	}
 
-			// For other files, use our content type handler
-			if filepath.Ext(r.URL.Path) != "" {
-				fileServerWithContentType.ServeHTTP(w, r)
+	if allowDirListing {
+		return fileServerWithContentType
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Handle index file specially
+		if r.URL.Path == "/" {
+			indexPath := filepath.Join(webFolder, "index.html")
+			if _, err := os.Stat(indexPath); err == nil {
+				w.Header().Set("Content-Type", "text/html")
+				http.ServeFile(w, r, indexPath)
 				return
 			}
+		}
 
-			http.NotFound(w, r)
-		}))
-	}
+		// For other files, use our content type handler
+		if filepath.Ext(r.URL.Path) != "" {
+			fileServerWithContentType.ServeHTTP(w, r)
+			return
+		}
 
-	return mux
+		http.NotFound(w, r)
+	})
 }
 
 func (s *Server) getPIDFilePath() string {
@@ -557,6 +1153,15 @@ func (m *Manager) GetServer(name string) (*Server, error) {
 	return server, nil
 }
 
+// Stats returns a snapshot of the server's request counters
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats.snapshot()
+	stats.Upstreams = upstreamStatus(s.pools)
+	return stats
+}
+
 // GetConfig returns the server configuration
 func (s *Server) GetConfig() config.InstanceConfig {
 	return s.config
@@ -566,3 +1171,18 @@ func (s *Server) GetConfig() config.InstanceConfig {
 func (s *Server) CreateHandler() http.Handler {
 	return s.createHandler()
 }
+
+// GetInstanceConfig returns the stored configuration of an instance, including
+// the running state recorded on disk
+func (m *Manager) GetInstanceConfig(name string) (config.InstanceConfig, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return config.InstanceConfig{}, fmt.Errorf("error loading config: %v", err)
+	}
+
+	instance, exists := cfg.GetInstance(name)
+	if !exists {
+		return config.InstanceConfig{}, fmt.Errorf("instance %s not found", name)
+	}
+	return instance, nil
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+func TestSaveConfigKeepsProcessState(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := config.DefaultConfig()
+	cfg.Instances["site"] = config.InstanceConfig{Name: "site", Port: 8080}
+	if err := config.SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := NewManager(configDir)
+
+	// Record the state as the supervisor of a background child would, after
+	// the manager has loaded the file
+	if err := config.SetInstanceStatus("site", true, 4242); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.RecordExit("site", 3, "exit status 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved config.Config
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	instance := saved.Instances["site"]
+	if !instance.IsRunning || instance.PID != 4242 {
+		t.Errorf("running state = %v, %d, want true, 4242", instance.IsRunning, instance.PID)
+	}
+	if instance.Restarts != 3 || instance.LastExit != "exit status 2" {
+		t.Errorf("restart history = %d, %q, want 3, %q", instance.Restarts, instance.LastExit, "exit status 2")
+	}
+}
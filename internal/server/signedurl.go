@@ -0,0 +1,136 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// Query parameters of a signed URL
+const (
+	SignatureExpiresParam = "expires"
+	SignatureParam        = "sig"
+)
+
+// resolveSignedURLs checks the signed URL settings of an instance and creates
+// its secret when it has none
+func resolveSignedURLs(cfg *config.SignedURLConfig) (*config.SignedURLConfig, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	resolved := *cfg
+	var err error
+	if resolved.Paths, err = cleanAuthPaths(cfg.Paths); err != nil {
+		return nil, err
+	}
+	if resolved.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("error generating signing secret: %v", err)
+		}
+		resolved.Secret = base64.RawURLEncoding.EncodeToString(secret)
+	}
+	return &resolved, nil
+}
+
+// signature returns the HMAC of a path and its expiry time
+func signature(secret, urlPath string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(urlPath + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkSignature reports whether a request carries a valid signature for its
+// path, and whether an otherwise valid signature has expired
+func checkSignature(secret string, r *http.Request) (bool, bool) {
+	query := r.URL.Query()
+	sig := query.Get(SignatureParam)
+	if sig == "" {
+		return false, false
+	}
+	expires, err := strconv.ParseInt(query.Get(SignatureExpiresParam), 10, 64)
+	if err != nil {
+		return false, false
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, r.URL.Path, expires))) {
+		return false, false
+	}
+	if time.Now().Unix() >= expires {
+		return false, true
+	}
+	return true, false
+}
+
+// withoutSignature returns a copy of a request without the signature
+// parameters, so they aren't passed on to proxy upstreams
+func withoutSignature(r *http.Request) *http.Request {
+	u := *r.URL
+	u.RawQuery = filterQuery(r.URL.RawQuery, func(key, value string) string {
+		if key == SignatureParam || key == SignatureExpiresParam {
+			return ""
+		}
+		return value
+	})
+	stripped := new(http.Request)
+	*stripped = *r
+	stripped.URL = &u
+	return stripped
+}
+
+// SignURL returns a URL for a path of an instance that is valid until expires.
+// The path must be below the instance's signed URL prefixes. The URL starts
+// with baseURL when given, for instances reached through another address, or
+// else with the instance's own address.
+func SignURL(instance config.InstanceConfig, urlPath string, expires time.Time, baseURL string) (string, error) {
+	if instance.SignedURLs == nil {
+		return "", fmt.Errorf("instance %s does not use signed URLs (add it with -signed-urls)", instance.Name)
+	}
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+	if !authProtects(instance.SignedURLs.Paths, urlPath) {
+		return "", fmt.Errorf("path %s is not below the signed URL paths of %s (%s)", urlPath, instance.Name, strings.Join(instance.SignedURLs.Paths, ", "))
+	}
+
+	var base *url.URL
+	if baseURL != "" {
+		var err error
+		if base, err = url.Parse(baseURL); err != nil || !isAbsoluteURL(baseURL) || base.Host == "" {
+			return "", fmt.Errorf("invalid base URL %q (expected an http or https URL)", baseURL)
+		}
+	} else {
+		scheme := "http"
+		if instance.TLS != nil {
+			scheme = "https"
+		}
+		host := probeHost(instance)
+		if host == "" {
+			host = "localhost"
+		}
+		if (scheme == "http" && instance.Port != 80) || (scheme == "https" && instance.Port != 443) {
+			host = fmt.Sprintf("%s:%d", host, instance.Port)
+		}
+		base = &url.URL{Scheme: scheme, Host: host}
+	}
+
+	exp := expires.Unix()
+	signed := url.URL{
+		Scheme: base.Scheme,
+		Host:   base.Host,
+		Path:   strings.TrimSuffix(base.Path, "/") + urlPath,
+		RawQuery: url.Values{
+			SignatureExpiresParam: {strconv.FormatInt(exp, 10)},
+			SignatureParam:        {signature(instance.SignedURLs.Secret, urlPath, exp)},
+		}.Encode(),
+	}
+	return signed.String(), nil
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// signedInstance returns an instance that requires a signed URL below
+// /private
+func signedInstance() config.InstanceConfig {
+	return config.InstanceConfig{
+		Name: "files",
+		Port: 8080,
+		SignedURLs: &config.SignedURLConfig{
+			Secret: "test-secret",
+			Paths:  []string{"/private"},
+		},
+	}
+}
+
+// signedTarget returns a request target for a path signed with a secret
+func signedTarget(secret, urlPath string, expires int64) string {
+	return fmt.Sprintf("%s?%s=%d&%s=%s", urlPath, SignatureExpiresParam, expires, SignatureParam, signature(secret, urlPath, expires))
+}
+
+func TestSignedURLs(t *testing.T) {
+	next := &passedOn{}
+	handler := withAuth(newAuthenticator(signedInstance()), nil, next)
+
+	secret := "test-secret"
+	valid := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Minute).Unix()
+	tampered := strings.Replace(signedTarget(secret, "/private/a.pdf", valid), "sig=", "sig=x", 1)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		body   string
+	}{
+		{"valid", signedTarget(secret, "/private/a.pdf", valid), http.StatusOK, ""},
+		{"unprotected", "/index.html", http.StatusOK, ""},
+		{"unsigned", "/private/a.pdf", http.StatusForbidden, "Forbidden"},
+		{"expired", signedTarget(secret, "/private/a.pdf", past), http.StatusForbidden, "This link has expired"},
+		{"tampered sig", tampered, http.StatusForbidden, "Forbidden"},
+		{"tampered expires", strings.Replace(signedTarget(secret, "/private/a.pdf", past), fmt.Sprint(past), fmt.Sprint(valid), 1), http.StatusForbidden, "Forbidden"},
+		{"other path", strings.Replace(signedTarget(secret, "/private/a.pdf", valid), "/a.pdf", "/b.pdf", 1), http.StatusForbidden, "Forbidden"},
+		{"other secret", signedTarget("other-secret", "/private/a.pdf", valid), http.StatusForbidden, "Forbidden"},
+	}
+	for _, tt := range tests {
+		next.request = nil
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		if tt.body != "" && strings.TrimSpace(rec.Body.String()) != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, rec.Body.String(), tt.body)
+		}
+		if tt.status == http.StatusOK && next.request.URL.RawQuery != "" && strings.HasPrefix(tt.target, "/private") {
+			t.Errorf("%s: signature passed on in %q", tt.name, next.request.URL.RawQuery)
+		}
+	}
+}
+
+func TestSignedURLsOnlyCoverTheirPaths(t *testing.T) {
+	instance := tokenInstance(t)
+	instance.TokenAuth.Paths = []string{"/admin"}
+	instance.SignedURLs = &config.SignedURLConfig{Secret: "test-secret", Paths: []string{"/private"}}
+	handler := withAuth(newAuthenticator(instance), nil, &passedOn{})
+
+	valid := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		target string
+		status int
+	}{
+		{signedTarget("test-secret", "/private/a.pdf", valid), http.StatusOK},
+		{signedTarget("test-secret", "/admin/users", valid), http.StatusUnauthorized},
+		{signedTarget("test-secret", "/admin", valid), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestSignURL(t *testing.T) {
+	instance := signedInstance()
+	expires := time.Now().Add(time.Hour)
+
+	signed, err := SignURL(instance, "private/a b.pdf", expires, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "http" || u.Host != "localhost:8080" || u.Path != "/private/a b.pdf" {
+		t.Errorf("signed URL %s has the wrong address", signed)
+	}
+
+	// The URL is accepted by the instance
+	rec := httptest.NewRecorder()
+	withAuth(newAuthenticator(instance), nil, &passedOn{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.RequestURI(), nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("signed URL %s answered %d", signed, rec.Code)
+	}
+
+	signed, err = SignURL(instance, "/private/a.pdf", expires, "https://files.example.com/base/")
+	if err != nil || !strings.HasPrefix(signed, "https://files.example.com/base/private/a.pdf?") {
+		t.Errorf("signed URL with a base URL: %s, %v", signed, err)
+	}
+
+	for _, urlPath := range []string{"/public/a.pdf", "/privateer", "/"} {
+		if _, err := SignURL(instance, urlPath, expires, ""); err == nil {
+			t.Errorf("SignURL signed %s, outside the signed URL paths", urlPath)
+		}
+	}
+	if _, err := SignURL(instance, "/private/a.pdf", expires, "files.example.com"); err == nil {
+		t.Error("SignURL accepted a base URL without a scheme")
+	}
+	if _, err := SignURL(config.InstanceConfig{Name: "plain"}, "/a", expires, ""); err == nil {
+		t.Error("SignURL signed a path of an instance without signed URLs")
+	}
+}
@@ -0,0 +1,422 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files in the root of the web folder that configure the site the way
+// Netlify-style hosting providers do
+const (
+	RedirectsFile = "_redirects"
+	HeadersFile   = "_headers"
+)
+
+// siteFilesCheckInterval is how often the site files are checked for changes
+const siteFilesCheckInterval = time.Second
+
+// placeholderPattern matches the :name placeholders of a redirect target
+var placeholderPattern = regexp.MustCompile(`:[A-Za-z_][A-Za-z0-9_]*`)
+
+// redirectRule is one line of a _redirects file
+type redirectRule struct {
+	host   string
+	from   string
+	query  map[string]string
+	to     string
+	status int
+	force  bool
+}
+
+// headerRule is one path block of a _headers file
+type headerRule struct {
+	path    string
+	headers http.Header
+}
+
+// parseRedirects parses a _redirects file. Lines that can't be used are
+// reported and skipped, as a hosting provider would.
+func parseRedirects(name string) ([]*redirectRule, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []*redirectRule
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRedirectLine(line)
+		if err != nil {
+			fmt.Printf("Warning: Skipping %s line %d: %v\n", name, n, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// parseRedirectLine parses a "FROM [key=value...] TO [STATUS[!]]" line
+func parseRedirectLine(line string) (*redirectRule, error) {
+	fields := strings.Fields(line)
+	rule := &redirectRule{status: http.StatusMovedPermanently}
+
+	from := fields[0]
+	if isAbsoluteURL(from) {
+		u, err := url.Parse(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source %q: %v", from, err)
+		}
+		rule.host = strings.ToLower(u.Hostname())
+		from = u.Path
+	}
+	if !strings.HasPrefix(from, "/") {
+		return nil, fmt.Errorf("source %q must start with /", fields[0])
+	}
+	rule.from = from
+
+	// Query parameters to match come between the source and the target
+	i := 1
+	for ; i < len(fields) && isQueryCondition(fields[i]); i++ {
+		key, value, _ := strings.Cut(fields[i], "=")
+		if rule.query == nil {
+			rule.query = make(map[string]string)
+		}
+		rule.query[key] = value
+	}
+	if i == len(fields) {
+		return nil, fmt.Errorf("missing target")
+	}
+	rule.to = fields[i]
+	if !strings.HasPrefix(rule.to, "/") && !isAbsoluteURL(rule.to) {
+		return nil, fmt.Errorf("target %q must start with / or be an http or https URL", rule.to)
+	}
+	i++
+
+	if i < len(fields) {
+		code, force := strings.CutSuffix(fields[i], "!")
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status %q", fields[i])
+		}
+		switch {
+		case status == http.StatusOK:
+		case status >= 300 && status < 400:
+			if status != http.StatusMovedPermanently && status != http.StatusFound && status != http.StatusSeeOther &&
+				status != http.StatusTemporaryRedirect && status != http.StatusPermanentRedirect {
+				return nil, fmt.Errorf("invalid redirect status %d", status)
+			}
+		case status >= 400 && status < 600:
+			if isAbsoluteURL(rule.to) {
+				return nil, fmt.Errorf("status %d needs a page in the site, not a URL", status)
+			}
+		default:
+			return nil, fmt.Errorf("invalid status %d", status)
+		}
+		rule.status = status
+		rule.force = force
+		i++
+	}
+
+	// Country, language, role and cookie conditions depend on the hosting
+	// provider, so such rules can't be previewed
+	if i < len(fields) {
+		return nil, fmt.Errorf("unsupported condition %q", fields[i])
+	}
+	return rule, nil
+}
+
+// isQueryCondition reports whether a _redirects field is a key=value query
+// parameter rather than a target
+func isQueryCondition(field string) bool {
+	key, _, ok := strings.Cut(field, "=")
+	return ok && key != "" && !strings.HasPrefix(field, "/") && !isAbsoluteURL(field)
+}
+
+// parseHeaders parses a _headers file: a path pattern on its own line
+// followed by indented "Name: value" lines
+func parseHeaders(name string) ([]*headerRule, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		rules   []*headerRule
+		current *headerRule
+	)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			current = &headerRule{path: line, headers: make(http.Header)}
+			rules = append(rules, current)
+			continue
+		}
+
+		header, value, ok := strings.Cut(line, ":")
+		if !ok || current == nil || strings.TrimSpace(header) == "" {
+			fmt.Printf("Warning: Skipping %s line %d: expected a path or a \"Name: value\" header\n", name, n)
+			continue
+		}
+		current.headers.Add(strings.TrimSpace(header), strings.TrimSpace(value))
+	}
+	return rules, scanner.Err()
+}
+
+// matchSitePath matches a request path against a path pattern of the site
+// files, where :name matches one segment and a trailing * matches the rest
+// of the path as :splat. Trailing slashes are ignored.
+func matchSitePath(pattern, urlPath string) (map[string]string, bool) {
+	patternSegments := strings.Split(strings.TrimSuffix(pattern, "/"), "/")
+	segments := strings.Split(strings.TrimSuffix(urlPath, "/"), "/")
+
+	params := make(map[string]string)
+	for i, p := range patternSegments {
+		if p == "*" && i == len(patternSegments)-1 {
+			if i < len(segments) {
+				params["splat"] = strings.Join(segments[i:], "/")
+			} else {
+				params["splat"] = ""
+			}
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		if name, ok := strings.CutPrefix(p, ":"); ok && name != "" {
+			params[name] = segments[i]
+			continue
+		}
+		if p != segments[i] {
+			return nil, false
+		}
+	}
+	return params, len(segments) == len(patternSegments)
+}
+
+// match returns the target of the rule for a request with its placeholders
+// filled in, or false when the rule doesn't match it
+func (rule *redirectRule) match(r *http.Request) (string, bool) {
+	if rule.host != "" && requestHost(r.Host) != rule.host {
+		return "", false
+	}
+	params, ok := matchSitePath(rule.from, r.URL.Path)
+	if !ok {
+		return "", false
+	}
+
+	if len(rule.query) > 0 {
+		query := r.URL.Query()
+		for key, value := range rule.query {
+			if !query.Has(key) {
+				return "", false
+			}
+			if name, ok := strings.CutPrefix(value, ":"); ok {
+				params[name] = query.Get(key)
+			} else if query.Get(key) != value {
+				return "", false
+			}
+		}
+	}
+
+	target := placeholderPattern.ReplaceAllStringFunc(rule.to, func(placeholder string) string {
+		if value, ok := params[placeholder[1:]]; ok {
+			return value
+		}
+		return placeholder
+	})
+	return target, true
+}
+
+// siteFile tracks a site file so changes can be detected
+type siteFile struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func statSiteFile(name string) siteFile {
+	info, err := os.Stat(name)
+	if err != nil || info.IsDir() {
+		return siteFile{}
+	}
+	return siteFile{modTime: info.ModTime(), size: info.Size(), exists: true}
+}
+
+// siteFiles holds the parsed site files of a web folder, reloading them when
+// they change
+type siteFiles struct {
+	webFolder string
+	mu        sync.Mutex
+	checked   time.Time
+	redirects siteFile
+	headers   siteFile
+
+	redirectRules []*redirectRule
+	headerRules   []*headerRule
+}
+
+// rules returns the current rules, reparsing a file that changed since it was
+// last checked
+func (sf *siteFiles) rules() ([]*redirectRule, []*headerRule) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	if time.Since(sf.checked) < siteFilesCheckInterval {
+		return sf.redirectRules, sf.headerRules
+	}
+	sf.checked = time.Now()
+
+	name := filepath.Join(sf.webFolder, RedirectsFile)
+	if current := statSiteFile(name); current != sf.redirects {
+		sf.redirects = current
+		sf.redirectRules = nil
+		if current.exists {
+			rules, err := parseRedirects(name)
+			if err != nil {
+				fmt.Printf("Error reading %s: %v\n", name, err)
+			}
+			sf.redirectRules = rules
+		}
+	}
+
+	name = filepath.Join(sf.webFolder, HeadersFile)
+	if current := statSiteFile(name); current != sf.headers {
+		sf.headers = current
+		sf.headerRules = nil
+		if current.exists {
+			rules, err := parseHeaders(name)
+			if err != nil {
+				fmt.Printf("Error reading %s: %v\n", name, err)
+			}
+			sf.headerRules = rules
+		}
+	}
+	return sf.redirectRules, sf.headerRules
+}
+
+// withSiteFiles applies the _headers and _redirects files of the web folder
+// to the requests it serves. Redirects follow the usual hosting rules: the
+// first matching line applies, a file that exists at the requested path is
+// served instead unless the status is forced with "!", 200 rewrites (or
+// proxies to a URL), 3xx redirects and 4xx/5xx serve the target page with
+// that status. The files are reloaded when they change and never served.
+func withSiteFiles(webFolder string, allowDirListing bool, next http.Handler) http.Handler {
+	sf := &siteFiles{webFolder: webFolder}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+RedirectsFile || r.URL.Path == "/"+HeadersFile {
+			http.NotFound(w, r)
+			return
+		}
+
+		redirects, headers := sf.rules()
+		for _, rule := range headers {
+			if _, ok := matchSitePath(rule.path, r.URL.Path); !ok {
+				continue
+			}
+			for name, values := range rule.headers {
+				if existing := w.Header().Get(name); existing != "" {
+					values = append([]string{existing}, values...)
+				}
+				w.Header().Set(name, strings.Join(values, ", "))
+			}
+		}
+
+		for _, rule := range redirects {
+			target, ok := rule.match(r)
+			if !ok {
+				continue
+			}
+			if !rule.force && resolvesToFile(webFolder, r.URL.Path, allowDirListing) {
+				break
+			}
+
+			switch {
+			case rule.status >= 300 && rule.status < 400:
+				http.Redirect(w, r, redirectLocation(target, r.URL.RawQuery), rule.status)
+			case isAbsoluteURL(target):
+				u, err := url.Parse(target)
+				if err != nil {
+					fmt.Printf("Error proxying %s to %s: %v\n", r.URL.Path, target, err)
+					http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+					return
+				}
+				proxyTo(u, r.URL.RawQuery).ServeHTTP(w, r)
+			case rule.status == http.StatusOK:
+				// The file server redirects requests for index.html to their
+				// directory, so such a target is served as it is
+				rewritten := rewriteRequest(r, target)
+				if path.Base(rewritten.URL.Path) == "index.html" {
+					name := filepath.Join(webFolder, filepath.FromSlash(path.Clean(rewritten.URL.Path)))
+					if info, err := os.Stat(name); err == nil && !info.IsDir() {
+						http.ServeFile(w, r, name)
+						return
+					}
+				}
+				next.ServeHTTP(w, rewritten)
+			default:
+				// Serve the target as the page of an error status
+				status := rule.status
+				page := strings.TrimPrefix(strings.SplitN(target, "?", 2)[0], "/")
+				withErrorPages(webFolder, map[int]string{status: page}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					http.Error(w, http.StatusText(status), status)
+				})).ServeHTTP(w, r)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rewriteRequest returns a copy of a request for another local path. The
+// query string is kept unless the target has its own.
+func rewriteRequest(r *http.Request, target string) *http.Request {
+	targetPath, query, hasQuery := strings.Cut(target, "?")
+	u := *r.URL
+	u.Path = targetPath
+	u.RawPath = ""
+	if hasQuery {
+		u.RawQuery = query
+	}
+	rewritten := new(http.Request)
+	*rewritten = *r
+	rewritten.URL = &u
+	return rewritten
+}
+
+// proxyTo forwards a request to a fixed URL, keeping the request's query
+// string unless the URL has its own
+func proxyTo(target *url.URL, rawQuery string) http.Handler {
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			u := *target
+			if u.RawQuery == "" {
+				u.RawQuery = rawQuery
+			}
+			r.Out.URL = &u
+			r.Out.Host = u.Host
+			r.SetXForwarded()
+		},
+	}
+}
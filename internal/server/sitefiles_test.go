@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSiteFileRedirects(t *testing.T) {
+	webFolder := t.TempDir()
+	redirects := "/x/*  /:splat  301\n" +
+		"/blog/:year/:slug  /posts/:year-:slug  302\n" +
+		"/go  https://example.com/landing  302\n"
+	if err := os.WriteFile(filepath.Join(webFolder, RedirectsFile), []byte(redirects), 0644); err != nil {
+		t.Fatal(err)
+	}
+	handler := withSiteFiles(webFolder, false, http.NotFoundHandler())
+
+	tests := []struct {
+		target   string
+		status   int
+		location string
+	}{
+		{"/x/docs/intro", http.StatusMovedPermanently, "/docs/intro"},
+		{"/x/docs?page=2", http.StatusMovedPermanently, "/docs?page=2"},
+		{"/blog/2024/hello", http.StatusFound, "/posts/2024-hello"},
+		{"/go?ref=a", http.StatusFound, "https://example.com/landing?ref=a"},
+
+		// A splat can't turn a local target into another host
+		{"/x//evil.example", http.StatusMovedPermanently, "/evil.example"},
+		{"/x///evil.example/path", http.StatusMovedPermanently, "/evil.example/path"},
+		{"/x/%5Cevil.example", http.StatusMovedPermanently, "/%5Cevil.example"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, rec.Code, tt.status)
+		}
+		if got := rec.Header().Get("Location"); got != tt.location {
+			t.Errorf("%s: Location %q, want %q", tt.target, got, tt.location)
+		}
+	}
+}
+
+func TestMatchSitePath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		ok      bool
+		params  map[string]string
+	}{
+		{"/news/:year/:slug", "/news/2024/launch", true, map[string]string{"year": "2024", "slug": "launch"}},
+		{"/news/:year/:slug", "/news/2024", false, nil},
+		{"/docs/*", "/docs/a/b", true, map[string]string{"splat": "a/b"}},
+		{"/docs/*", "/docs", true, map[string]string{"splat": ""}},
+		{"/docs/*", "/docs//evil", true, map[string]string{"splat": "/evil"}},
+		{"/about/", "/about", true, map[string]string{}},
+		{"/about", "/contact", false, nil},
+	}
+
+	for _, tt := range tests {
+		params, ok := matchSitePath(tt.pattern, tt.path)
+		if ok != tt.ok {
+			t.Errorf("matchSitePath(%q, %q) = %v, want %v", tt.pattern, tt.path, ok, tt.ok)
+			continue
+		}
+		for name, want := range tt.params {
+			if params[name] != want {
+				t.Errorf("matchSitePath(%q, %q): %s = %q, want %q", tt.pattern, tt.path, name, params[name], want)
+			}
+		}
+	}
+}
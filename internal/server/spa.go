@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// spaHandler serves a fallback document for client-side routes of a
+// single-page application. Paths that resolve to a real file are served by
+// files, as are missing paths with a file extension so missing assets still
+// return a real 404.
+func spaHandler(webFolder string, allowDirListing bool, fallback string, files http.Handler) http.Handler {
+	fallbackPath := filepath.Join(webFolder, filepath.FromSlash(fallback))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path.Ext(r.URL.Path) != "" || resolvesToFile(webFolder, r.URL.Path, allowDirListing) {
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		file, err := os.Open(fallbackPath)
+		if err != nil {
+			files.ServeHTTP(w, r)
+			return
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil || info.IsDir() {
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		// Client-side routes must not be cached as if they were the document
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, fallbackPath, info.ModTime(), file)
+	})
+}
+
+// resolvesToFile reports whether a URL path maps to something the file server
+// can serve: a regular file, a directory with an index.html, or any directory
+// when listings are allowed
+func resolvesToFile(webFolder, urlPath string, allowDirListing bool) bool {
+	name := filepath.Join(webFolder, filepath.FromSlash(path.Clean("/"+urlPath)))
+	info, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+	if !info.IsDir() {
+		return true
+	}
+	if allowDirListing {
+		return true
+	}
+	_, err = os.Stat(filepath.Join(name, "index.html"))
+	return err == nil
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the request counters of a running server
+type Stats struct {
+	StartedAt time.Time `json:"started_at"`
+	Uptime    string    `json:"uptime"`
+	Requests  uint64    `json:"requests"`
+	InFlight  int64     `json:"in_flight"`
+	BytesSent uint64    `json:"bytes_sent"`
+
+	Upstreams []UpstreamStatus `json:"upstreams,omitempty"`
+}
+
+// stats holds the live request counters of a server
+type stats struct {
+	startedAt time.Time
+	requests  atomic.Uint64
+	inFlight  atomic.Int64
+	bytesSent atomic.Uint64
+	metrics   *requestMetrics
+
+	// Requests refused by the rate limit and the concurrent request limit
+	rateLimited        atomic.Uint64
+	concurrencyLimited atomic.Uint64
+}
+
+func newStats() *stats {
+	return &stats{startedAt: time.Now(), metrics: newRequestMetrics()}
+}
+
+func (st *stats) snapshot() Stats {
+	return Stats{
+		StartedAt: st.startedAt,
+		Uptime:    time.Since(st.startedAt).Round(time.Second).String(),
+		Requests:  st.requests.Load(),
+		InFlight:  st.inFlight.Load(),
+		BytesSent: st.bytesSent.Load(),
+	}
+}
+
+// trackStats wraps a handler so every request updates the server's counters
+func (s *Server) trackStats(next http.Handler) http.Handler {
+	st := s.stats
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		st.requests.Add(1)
+		st.inFlight.Add(1)
+		defer st.inFlight.Add(-1)
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+		st.bytesSent.Add(uint64(rec.bytes))
+		st.metrics.observe(r.Method, rec.Status(), time.Since(start))
+	})
+}
+
+// responseRecorder captures the status code and body size of a response
+// while passing everything through to the underlying writer
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the response status code, defaulting to 200 when the
+// handler never wrote a header
+func (r *responseRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection over to the handler, which is how protocol
+// upgrades such as WebSockets are served
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := r.ResponseWriter.(http.Hijacker); ok {
+		conn, rw, err := h.Hijack()
+		if err == nil && r.status == 0 {
+			r.status = http.StatusSwitchingProtocols
+		}
+		return conn, rw, err
+	}
+	return nil, nil, fmt.Errorf("response writer does not support hijacking")
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mguptahub/nanoHttp/internal/ca"
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// tlsVersions maps the accepted minimum TLS version names to their constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ValidateTLS checks that an instance's TLS configuration can be loaded,
+// without starting a server
+func ValidateTLS(name string, cfg *config.TLSConfig) error {
+	_, err := buildTLSConfig(name, cfg)
+	return err
+}
+
+// buildTLSConfig loads the certificate and translates the instance's TLS
+// settings into a tls.Config. Certificates issued by the local CA are renewed
+// here when they are close to expiry. It returns nil when TLS is not configured.
+func buildTLSConfig(name string, cfg *config.TLSConfig) (*tls.Config, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	certFile, keyFile := cfg.CertFile, cfg.KeyFile
+	if cfg.Auto {
+		configDir, err := config.GetConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("error getting config directory: %v", err)
+		}
+		if certFile, keyFile, err = ca.EnsureLeaf(configDir, name, cfg.Hosts); err != nil {
+			return nil, fmt.Errorf("error issuing local certificate: %v", err)
+		}
+	}
+
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both a TLS certificate and key are required")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading TLS certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.MinVersion != "" {
+		version, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS version %q (expected 1.0, 1.1, 1.2 or 1.3)", cfg.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if len(cfg.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			suites[suite.Name] = suite.ID
+		}
+
+		for _, name := range cfg.CipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// redirectToHTTPS returns a handler that sends every request to the same
+// host and path on the HTTPS port
+func redirectToHTTPS(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(httpsPort))
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
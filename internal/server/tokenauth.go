@@ -0,0 +1,245 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// Ways a client can pass a token besides an "Authorization: Bearer" header
+const (
+	APIKeyHeader    = "X-API-Key"
+	TokenQueryParam = "access_token"
+)
+
+// tokenPrefix starts every issued token, so secret scanners can tell what it
+// is
+const tokenPrefix = "nh_"
+
+// resolveTokenAuth checks the token auth settings of an instance
+func resolveTokenAuth(cfg *config.TokenAuthConfig) (*config.TokenAuthConfig, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	resolved := *cfg
+	var err error
+	if resolved.Paths, err = cleanAuthPaths(cfg.Paths); err != nil {
+		return nil, err
+	}
+	return &resolved, nil
+}
+
+// hashToken returns the form a token is stored in
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// requestToken returns the token a request carries in its Authorization
+// header, API key header or query string
+func requestToken(r *http.Request) (string, bool) {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token), true
+	}
+	if token := r.Header.Get(APIKeyHeader); token != "" {
+		return token, true
+	}
+	if token := r.URL.Query().Get(TokenQueryParam); token != "" {
+		return token, true
+	}
+	return "", false
+}
+
+// withoutToken returns a copy of a request without its token, so it isn't
+// passed on to proxy upstreams
+func withoutToken(r *http.Request) *http.Request {
+	stripped := new(http.Request)
+	*stripped = *r
+	stripped.Header = r.Header.Clone()
+	if scheme, _, _ := strings.Cut(stripped.Header.Get("Authorization"), " "); strings.EqualFold(scheme, "Bearer") {
+		stripped.Header.Del("Authorization")
+	}
+	stripped.Header.Del(APIKeyHeader)
+
+	if r.URL.RawQuery != "" {
+		u := *r.URL
+		u.RawQuery = filterQuery(r.URL.RawQuery, func(key, value string) string {
+			if key == TokenQueryParam {
+				return ""
+			}
+			return value
+		})
+		stripped.URL = &u
+	}
+	return stripped
+}
+
+// redactTokenQuery hides a token passed in a query string, so it doesn't end
+// up in logs
+func redactTokenQuery(rawQuery string) string {
+	if !strings.Contains(rawQuery, TokenQueryParam) {
+		return rawQuery
+	}
+	return filterQuery(rawQuery, func(key, value string) string {
+		if key == TokenQueryParam {
+			return key + "=REDACTED"
+		}
+		return value
+	})
+}
+
+// filterQuery rewrites the parameters of a raw query string in place, keeping
+// their order. A parameter for which replace returns an empty string is
+// dropped.
+func filterQuery(rawQuery string, replace func(key, value string) string) string {
+	var kept []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		key, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if param = replace(key, param); param != "" {
+			kept = append(kept, param)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+// tokenStore holds the tokens of an instance. Tokens are created and revoked
+// by editing the config file, so they are reloaded when it changes.
+type tokenStore struct {
+	name       string
+	configPath string
+	mu         sync.Mutex
+	checked    time.Time
+	file       siteFile
+	tokens     []config.AccessToken
+}
+
+func newTokenStore(name string, tokens []config.AccessToken) *tokenStore {
+	store := &tokenStore{name: name, tokens: tokens, checked: time.Now()}
+	if configPath, err := config.GetConfigPath(); err == nil {
+		store.configPath = configPath
+		store.file = statSiteFile(configPath)
+	}
+	return store
+}
+
+// lookup returns the unexpired token matching the one a client sent
+func (ts *tokenStore) lookup(token string) (config.AccessToken, bool) {
+	ts.mu.Lock()
+	if ts.configPath != "" && time.Since(ts.checked) >= siteFilesCheckInterval {
+		ts.checked = time.Now()
+		if current := statSiteFile(ts.configPath); current != ts.file {
+			ts.file = current
+			if cfg, err := config.LoadConfig(); err != nil {
+				fmt.Printf("Error reloading tokens: %v\n", err)
+			} else if instance, ok := cfg.Instances[ts.name]; ok && instance.TokenAuth != nil {
+				ts.tokens = instance.TokenAuth.Tokens
+			} else {
+				ts.tokens = nil
+			}
+		}
+	}
+	tokens := ts.tokens
+	ts.mu.Unlock()
+
+	hash := []byte(hashToken(token))
+	now := time.Now()
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(t.Hash)) == 1 && !t.Expired(now) {
+			return t, true
+		}
+	}
+	return config.AccessToken{}, false
+}
+
+// CreateToken issues a token for an instance that uses token auth, limited to
+// the given path prefixes and valid until expires unless it is zero. Only its
+// hash is stored, so the returned token can't be shown again.
+func (m *Manager) CreateToken(name, tokenName string, paths []string, expires time.Time) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	server, exists := m.servers[name]
+	if !exists {
+		return "", fmt.Errorf("instance %s not found", name)
+	}
+	if server.config.TokenAuth == nil {
+		return "", fmt.Errorf("instance %s does not use token auth (add it with -token-auth)", name)
+	}
+	if tokenName == "" {
+		return "", fmt.Errorf("token name is required")
+	}
+	for _, t := range server.config.TokenAuth.Tokens {
+		if t.Name == tokenName {
+			return "", fmt.Errorf("token %s already exists", tokenName)
+		}
+	}
+	paths, err := cleanAuthPaths(paths)
+	if err != nil {
+		return "", err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("error generating token: %v", err)
+	}
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	issued := config.AccessToken{
+		Name:    tokenName,
+		Hash:    hashToken(token),
+		Paths:   paths,
+		Created: time.Now().UTC().Truncate(time.Second),
+	}
+	if !expires.IsZero() {
+		expires = expires.UTC().Truncate(time.Second)
+		issued.Expires = &expires
+	}
+
+	tokenAuth := *server.config.TokenAuth
+	tokenAuth.Tokens = append(append([]config.AccessToken(nil), tokenAuth.Tokens...), issued)
+	server.config.TokenAuth = &tokenAuth
+	if err := m.saveConfig(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RevokeToken removes a token from an instance
+func (m *Manager) RevokeToken(name, tokenName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	server, exists := m.servers[name]
+	if !exists {
+		return fmt.Errorf("instance %s not found", name)
+	}
+	if server.config.TokenAuth == nil {
+		return fmt.Errorf("instance %s does not use token auth", name)
+	}
+
+	tokenAuth := *server.config.TokenAuth
+	tokenAuth.Tokens = nil
+	for _, t := range server.config.TokenAuth.Tokens {
+		if t.Name != tokenName {
+			tokenAuth.Tokens = append(tokenAuth.Tokens, t)
+		}
+	}
+	if len(tokenAuth.Tokens) == len(server.config.TokenAuth.Tokens) {
+		return fmt.Errorf("token %s not found", tokenName)
+	}
+	server.config.TokenAuth = &tokenAuth
+	return m.saveConfig()
+}
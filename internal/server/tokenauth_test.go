@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// Tokens of the test instance
+const (
+	siteToken    = "nh_site"
+	docsToken    = "nh_docs"
+	expiredToken = "nh_expired"
+)
+
+// tokenInstance returns an instance that uses token auth with a token for the
+// whole site, one for /docs and an expired one
+func tokenInstance(t *testing.T) config.InstanceConfig {
+	t.Setenv("HOME", t.TempDir())
+	expired := time.Now().Add(-time.Hour)
+	return config.InstanceConfig{
+		Name: "site",
+		TokenAuth: &config.TokenAuthConfig{
+			Tokens: []config.AccessToken{
+				{Name: "site", Hash: hashToken(siteToken)},
+				{Name: "docs", Hash: hashToken(docsToken), Paths: []string{"/docs"}},
+				{Name: "expired", Hash: hashToken(expiredToken), Expires: &expired},
+			},
+		},
+	}
+}
+
+// passedOn records the request a handler was passed
+type passedOn struct {
+	request *http.Request
+}
+
+func (p *passedOn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.request = r
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestRequestToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		target string
+		token  string
+	}{
+		{"bearer", "Authorization", "Bearer nh_a", "/", "nh_a"},
+		{"bearer any case", "Authorization", "bearer  nh_a ", "/", "nh_a"},
+		{"api key", APIKeyHeader, "nh_b", "/", "nh_b"},
+		{"query", "", "", "/?x=1&access_token=nh_c", "nh_c"},
+		{"basic", "Authorization", "Basic YTpi", "/", ""},
+		{"none", "", "", "/?x=1", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		token, ok := requestToken(r)
+		if token != tt.token || ok != (tt.token != "") {
+			t.Errorf("%s: requestToken = %q, %v, want %q", tt.name, token, ok, tt.token)
+		}
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	next := &passedOn{}
+	handler := withAuth(newAuthenticator(tokenInstance(t)), nil, next)
+
+	tests := []struct {
+		name   string
+		target string
+		header string
+		value  string
+		status int
+	}{
+		{"no token", "/", "", "", http.StatusUnauthorized},
+		{"bearer", "/page", "Authorization", "Bearer " + siteToken, http.StatusOK},
+		{"api key", "/page", APIKeyHeader, siteToken, http.StatusOK},
+		{"query", "/page?access_token=" + siteToken, "", "", http.StatusOK},
+		{"unknown token", "/page", "Authorization", "Bearer nh_unknown", http.StatusUnauthorized},
+		{"expired token", "/page", APIKeyHeader, expiredToken, http.StatusUnauthorized},
+		{"token path", "/docs/intro", APIKeyHeader, docsToken, http.StatusOK},
+		{"token path case", "/Docs/intro", APIKeyHeader, docsToken, http.StatusOK},
+		{"outside token paths", "/private", APIKeyHeader, docsToken, http.StatusForbidden},
+		{"outside token paths by prefix", "/docsecret", APIKeyHeader, docsToken, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		if tt.status == http.StatusUnauthorized && !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Bearer ") {
+			t.Errorf("%s: no Bearer challenge", tt.name)
+		}
+	}
+}
+
+func TestTokenAuthPaths(t *testing.T) {
+	instance := tokenInstance(t)
+	instance.TokenAuth.Paths = []string{"/api"}
+	handler := withAuth(newAuthenticator(instance), []string{"/api/healthz"}, &passedOn{})
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/", http.StatusOK},
+		{"/apis", http.StatusOK},
+		{"/api", http.StatusUnauthorized},
+		{"/api/users", http.StatusUnauthorized},
+		{"/api/healthz", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestTokenRemovedBeforePassingOn(t *testing.T) {
+	next := &passedOn{}
+	handler := withAuth(newAuthenticator(tokenInstance(t)), nil, next)
+
+	r := httptest.NewRequest(http.MethodGet, "/api?b=2&access_token="+siteToken+"&a=1", nil)
+	r.Header.Set("Authorization", "Bearer "+siteToken)
+	r.Header.Set(APIKeyHeader, siteToken)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	passed := next.request
+	if passed == nil {
+		t.Fatal("request wasn't passed on")
+	}
+	if passed.Header.Get("Authorization") != "" || passed.Header.Get(APIKeyHeader) != "" {
+		t.Errorf("token headers passed on: %v", passed.Header)
+	}
+	if passed.URL.RawQuery != "b=2&a=1" {
+		t.Errorf("query passed on as %q, want b=2&a=1", passed.URL.RawQuery)
+	}
+	if r.Header.Get("Authorization") == "" || !strings.Contains(r.URL.RawQuery, siteToken) {
+		t.Error("the original request was changed")
+	}
+}
+
+func TestWithoutTokenKeepsBasicAuth(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/?x=1", nil)
+	r.SetBasicAuth("alice", "secret")
+	stripped := withoutToken(r)
+	if user, _, ok := stripped.BasicAuth(); !ok || user != "alice" {
+		t.Error("Basic credentials were removed")
+	}
+	if stripped.URL.RawQuery != "x=1" {
+		t.Errorf("query changed to %q", stripped.URL.RawQuery)
+	}
+}
+
+// bufferCloser is an access log destination kept in memory
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error {
+	return nil
+}
+
+func TestTokenRedactedFromAccessLog(t *testing.T) {
+	out := &bufferCloser{}
+	logger := &accessLogger{out: out, format: LogFormatCommon}
+	handler := withAccessLog(logger, withAuth(newAuthenticator(tokenInstance(t)), nil, &passedOn{}))
+
+	for _, target := range []string{"/page?access_token=" + siteToken, "/page?access_token=nh_wrong&x=1"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	logged := out.String()
+	if strings.Contains(logged, siteToken) || strings.Contains(logged, "nh_wrong") {
+		t.Errorf("token written to the access log:\n%s", logged)
+	}
+	if !strings.Contains(logged, "/page?access_token=REDACTED ") || !strings.Contains(logged, "/page?access_token=REDACTED&x=1 ") {
+		t.Errorf("redacted queries missing from the access log:\n%s", logged)
+	}
+}
+
+func TestTokenStoreReloads(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	instance := config.InstanceConfig{Name: "site", TokenAuth: &config.TokenAuthConfig{}}
+	cfg := config.DefaultConfig()
+	cfg.Instances["site"] = instance
+	if err := config.SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newTokenStore("site", nil)
+	if _, ok := store.lookup(siteToken); ok {
+		t.Fatal("lookup found a token that wasn't issued")
+	}
+
+	// Issue the token, as another process would, and let the store check the
+	// file again
+	instance.TokenAuth.Tokens = []config.AccessToken{{Name: "site", Hash: hashToken(siteToken)}}
+	cfg.Instances["site"] = instance
+	if err := config.SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	store.checked = time.Time{}
+	if token, ok := store.lookup(siteToken); !ok || token.Name != "site" {
+		t.Fatal("lookup didn't find a token issued after the store was created")
+	}
+
+	instance.TokenAuth.Tokens = nil
+	cfg.Instances["site"] = instance
+	if err := config.SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	store.checked = time.Time{}
+	if _, ok := store.lookup(siteToken); ok {
+		t.Error("lookup found a revoked token")
+	}
+}
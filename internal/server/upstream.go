@@ -0,0 +1,327 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// Load balancing strategies for proxy routes with several upstreams
+const (
+	StrategyRoundRobin = "round-robin"
+	StrategyLeastConn  = "least-conn"
+	StrategyIPHash     = "ip-hash"
+)
+
+// Health check defaults
+const (
+	DefaultHealthCheckInterval = 10 * time.Second
+	DefaultHealthCheckTimeout  = 2 * time.Second
+
+	// unhealthyAfter consecutive failed checks eject an upstream
+	unhealthyAfter = 2
+)
+
+// UpstreamStatus is a snapshot of one upstream of a proxy route
+type UpstreamStatus struct {
+	Route     string    `json:"route"`
+	URL       string    `json:"url"`
+	Healthy   bool      `json:"healthy"`
+	Active    int64     `json:"active"`
+	LastError string    `json:"last_error,omitempty"`
+	LastCheck time.Time `json:"last_check,omitempty"`
+}
+
+// ValidateStrategy checks that a load balancing strategy is known
+func ValidateStrategy(strategy string) error {
+	switch strategy {
+	case "", StrategyRoundRobin, StrategyLeastConn, StrategyIPHash:
+		return nil
+	}
+	return fmt.Errorf("invalid load balancing strategy %q (expected %s, %s or %s)",
+		strategy, StrategyRoundRobin, StrategyLeastConn, StrategyIPHash)
+}
+
+// upstream is one backend of a pool
+type upstream struct {
+	raw      string
+	target   *url.URL
+	healthy  atomic.Bool
+	active   atomic.Int64
+	mu       sync.Mutex
+	failures int
+	lastErr  string
+	checked  time.Time
+}
+
+// pool balances the requests of a proxy route across its upstreams. Clients
+// are told apart for ip-hash as the IP filter and rate limit do, through the
+// trusted proxies.
+type pool struct {
+	route     config.ProxyRoute
+	trusted   []netip.Prefix
+	upstreams []*upstream
+	next      atomic.Uint64
+}
+
+func newPool(route config.ProxyRoute, trusted []netip.Prefix) (*pool, error) {
+	p := &pool{route: route, trusted: trusted}
+	for _, raw := range route.Targets() {
+		target, err := parseUpstream(raw)
+		if err != nil {
+			return nil, err
+		}
+		u := &upstream{raw: raw, target: target}
+		u.healthy.Store(true)
+		p.upstreams = append(p.upstreams, u)
+	}
+	if len(p.upstreams) == 0 {
+		return nil, fmt.Errorf("proxy route %s has no upstream", route.Prefix)
+	}
+	return p, nil
+}
+
+// pick chooses the upstream for a request, skipping those already tried.
+// Ejected upstreams are only used when no healthy one is left.
+func (p *pool) pick(r *http.Request, tried map[*upstream]bool) *upstream {
+	var candidates []*upstream
+	for _, u := range p.upstreams {
+		if !tried[u] && u.healthy.Load() {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, u := range p.upstreams {
+			if !tried[u] {
+				candidates = append(candidates, u)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	switch p.route.Strategy {
+	case StrategyLeastConn:
+		best := candidates[0]
+		for _, u := range candidates[1:] {
+			if u.active.Load() < best.active.Load() {
+				best = u
+			}
+		}
+		return best
+	case StrategyIPHash:
+		// Hash over the full list so a client keeps its upstream while the
+		// pool is healthy
+		h := fnv.New32a()
+		h.Write([]byte(clientAddress(p.trusted, r)))
+		start := int(h.Sum32() % uint32(len(p.upstreams)))
+		for i := range p.upstreams {
+			u := p.upstreams[(start+i)%len(p.upstreams)]
+			for _, c := range candidates {
+				if c == u {
+					return u
+				}
+			}
+		}
+		return candidates[0]
+	default:
+		n := p.next.Add(1) - 1
+		return candidates[n%uint64(len(candidates))]
+	}
+}
+
+// healthCheck probes every upstream until ctx is done, calling report after
+// each round
+func (p *pool) healthCheck(ctx context.Context, report func()) {
+	check := p.route.HealthCheck
+	interval := time.Duration(check.Interval)
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	timeout := time.Duration(check.Timeout)
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, u := range p.upstreams {
+			if u.probe(ctx, client, check.Path) {
+				state := "restored"
+				if !u.healthy.Load() {
+					state = "ejected"
+				}
+				fmt.Printf("Upstream %s of %s %s\n", u.raw, p.route.Prefix, state)
+			}
+		}
+		if ctx.Err() == nil {
+			report()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe checks an upstream once and reports whether its health changed
+func (u *upstream) probe(ctx context.Context, client *http.Client, path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	target := *u.target
+	target.Path = singleJoiningSlash(target.Path, path)
+
+	var probeErr error
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err == nil {
+		var resp *http.Response
+		if resp, err = client.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 400 {
+				probeErr = fmt.Errorf("health check returned %d", resp.StatusCode)
+			}
+		} else {
+			probeErr = err
+		}
+	} else {
+		probeErr = err
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.checked = time.Now()
+	was := u.healthy.Load()
+	if probeErr != nil {
+		u.failures++
+		u.lastErr = probeErr.Error()
+		if u.failures >= unhealthyAfter {
+			u.healthy.Store(false)
+		}
+	} else {
+		u.failures = 0
+		u.lastErr = ""
+		u.healthy.Store(true)
+	}
+	return was != u.healthy.Load()
+}
+
+// status returns a snapshot of every upstream of the pool
+func (p *pool) status() []UpstreamStatus {
+	statuses := make([]UpstreamStatus, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		u.mu.Lock()
+		statuses = append(statuses, UpstreamStatus{
+			Route:     p.route.Prefix,
+			URL:       u.raw,
+			Healthy:   u.healthy.Load(),
+			Active:    u.active.Load(),
+			LastError: u.lastErr,
+			LastCheck: u.checked,
+		})
+		u.mu.Unlock()
+	}
+	return statuses
+}
+
+// singleJoiningSlash joins two URL paths with exactly one slash between them
+func singleJoiningSlash(a, b string) string {
+	switch aslash, bslash := len(a) > 0 && a[len(a)-1] == '/', len(b) > 0 && b[0] == '/'; {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// upstreamStatePath returns the file a server process publishes the state of
+// its upstreams to, so other nanoHttp commands can show it
+func upstreamStatePath(name string) (string, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "run", name+"-upstreams.json"), nil
+}
+
+// writeUpstreamState publishes the current state of the server's upstreams
+func (s *Server) writeUpstreamState(pools []*pool) {
+	path, err := upstreamStatePath(s.config.Name)
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(upstreamStatus(pools))
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Printf("Error writing upstream state: %v\n", err)
+		return
+	}
+	// Replace the file atomically so readers never see a partial write
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		fmt.Printf("Error writing upstream state: %v\n", err)
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// removeUpstreamState removes the published upstream state
+func (s *Server) removeUpstreamState() {
+	if path, err := upstreamStatePath(s.config.Name); err == nil {
+		os.Remove(path)
+	}
+}
+
+// ReadUpstreamState returns the upstream state last published by the process
+// serving an instance
+func ReadUpstreamState(name string) ([]UpstreamStatus, error) {
+	path, err := upstreamStatePath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []UpstreamStatus
+	if err := json.Unmarshal(data, &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// upstreamStatus returns a snapshot of the upstreams of every pool
+func upstreamStatus(pools []*pool) []UpstreamStatus {
+	var statuses []UpstreamStatus
+	for _, p := range pools {
+		statuses = append(statuses, p.status()...)
+	}
+	return statuses
+}
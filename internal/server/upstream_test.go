@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+func TestIPHashBehindTrustedProxy(t *testing.T) {
+	route := config.ProxyRoute{
+		Prefix:    "/api",
+		Upstreams: []string{"http://127.0.0.1:9001", "http://127.0.0.1:9002", "http://127.0.0.1:9003"},
+		Strategy:  StrategyIPHash,
+	}
+	p, err := newPool(route, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pick := func(remoteAddr, forwardedFor string) *upstream {
+		r := httptest.NewRequest(http.MethodGet, "/api", nil)
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		return p.pick(r, nil)
+	}
+
+	// Clients behind the proxy are spread like clients that connect directly
+	picked := make(map[*upstream]bool)
+	for _, client := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.5", "192.0.2.6"} {
+		u := pick("10.0.0.1:1000", client)
+		if direct := pick(client+":1000", ""); u != direct {
+			t.Errorf("%s: upstream %s behind the proxy, %s directly", client, u.raw, direct.raw)
+		}
+		picked[u] = true
+	}
+	if len(picked) < 2 {
+		t.Error("every client behind the proxy went to the same upstream")
+	}
+
+	// An untrusted sender can't choose its upstream
+	if u, direct := pick("192.0.2.9:1000", "192.0.2.1"), pick("192.0.2.9:1000", ""); u != direct {
+		t.Errorf("X-Forwarded-For of an untrusted client moved it from %s to %s", direct.raw, u.raw)
+	}
+}
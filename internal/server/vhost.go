@@ -0,0 +1,392 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+// normalizeHosts validates the hostnames of a virtual host and returns them
+// in lower case. A leading "*." matches any subdomain.
+func normalizeHosts(hosts []string) ([]string, error) {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+		if host == "" {
+			continue
+		}
+		if strings.ContainsAny(host, ":/ ") {
+			return nil, fmt.Errorf("invalid host %q (expected a hostname without scheme, port or path)", host)
+		}
+		if name := strings.TrimPrefix(host, "*."); name == "" || strings.Contains(name, "*") {
+			return nil, fmt.Errorf("invalid host %q (wildcards are only allowed as a leading \"*.\")", host)
+		}
+		if seen[host] {
+			return nil, fmt.Errorf("duplicate host %s", host)
+		}
+		seen[host] = true
+		normalized = append(normalized, host)
+	}
+	return normalized, nil
+}
+
+// checkSharedPort reports whether an instance may use its port alongside the
+// configured instances. A port can only be shared by virtual hosts that agree
+// on TLS, claim distinct hostnames and have at most one default site.
+func checkSharedPort(instance config.InstanceConfig, servers map[string]*Server) error {
+	for name, server := range servers {
+		other := server.config
+		if name == instance.Name || other.Port != instance.Port {
+			continue
+		}
+		if !instance.VirtualHost() || !other.VirtualHost() {
+			return fmt.Errorf("port %d is already used by instance %s (give both instances -host names to share it)", instance.Port, name)
+		}
+		if (instance.TLS == nil) != (other.TLS == nil) {
+			return fmt.Errorf("port %d is shared with instance %s, and instances sharing a port must all use HTTPS or all use plain HTTP", instance.Port, name)
+		}
+		if instance.DefaultHost && other.DefaultHost {
+			return fmt.Errorf("instance %s is already the default site on port %d", name, instance.Port)
+		}
+		for _, host := range instance.Hosts {
+			for _, otherHost := range other.Hosts {
+				if host == otherHost {
+					return fmt.Errorf("host %s is already served by instance %s on port %d", host, name, instance.Port)
+				}
+			}
+		}
+		if instance.TLS != nil && instance.TLS.RedirectPort > 0 && instance.TLS.RedirectPort == other.TLS.RedirectPort {
+			return fmt.Errorf("redirect port %d is already used by instance %s", instance.TLS.RedirectPort, name)
+		}
+	}
+	return nil
+}
+
+// requestHost returns the hostname a request was sent to, without its port
+func requestHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+}
+
+// matchHost reports whether a hostname matches an exact or wildcard pattern
+func matchHost(pattern, host string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return len(host) > len(suffix) && strings.HasSuffix(host, suffix)
+	}
+	return pattern == host
+}
+
+// sharedListeners holds the listeners of this process that are shared by
+// virtual hosts, by port
+var sharedListeners = struct {
+	sync.Mutex
+	ports map[int]*sharedListener
+}{ports: make(map[int]*sharedListener)}
+
+// site is a virtual host served on a shared listener
+type site struct {
+	server    *Server
+	hosts     []string
+	isDefault bool
+	handler   http.Handler
+	cert      *tls.Certificate
+	done      chan error
+}
+
+// sharedListener serves the virtual hosts of one port, handing every request
+// to the site its Host header selects
+type sharedListener struct {
+	port   int
+	tls    bool
+	server *http.Server
+	mu     sync.RWMutex
+	sites  []*site
+}
+
+// joinShared adds a site to the shared listener of a port, binding the port
+// when the site is the first one on it
+func joinShared(port int, tlsConfig *tls.Config, st *site) (*sharedListener, error) {
+	sharedListeners.Lock()
+	defer sharedListeners.Unlock()
+
+	if sl := sharedListeners.ports[port]; sl != nil {
+		if sl.tls != (tlsConfig != nil) {
+			return nil, fmt.Errorf("port %d is already serving virtual hosts with a different TLS setting", port)
+		}
+		sl.mu.Lock()
+		defer sl.mu.Unlock()
+		for _, other := range sl.sites {
+			if st.isDefault && other.isDefault {
+				return nil, fmt.Errorf("%s is already the default site on port %d", other.server.config.Name, port)
+			}
+			for _, host := range st.hosts {
+				for _, otherHost := range other.hosts {
+					if host == otherHost {
+						return nil, fmt.Errorf("host %s is already served by %s on port %d", host, other.server.config.Name, port)
+					}
+				}
+			}
+		}
+		sl.sites = append(sl.sites, st)
+		return sl, nil
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("error listening on port %d: %v", port, err)
+	}
+
+	sl := &sharedListener{port: port, tls: tlsConfig != nil, sites: []*site{st}}
+	sl.server = &http.Server{Handler: sl}
+	if tlsConfig != nil {
+		// Every site brings its own certificate, chosen by the SNI hostname
+		shared := tlsConfig.Clone()
+		shared.Certificates = nil
+		shared.GetCertificate = sl.certificate
+		sl.server.TLSConfig = shared
+	}
+	sharedListeners.ports[port] = sl
+
+	go sl.serve(listener)
+	return sl, nil
+}
+
+// serve accepts connections until the last site leaves. If serving fails
+// instead, every site is told it has stopped.
+func (sl *sharedListener) serve(listener net.Listener) {
+	var err error
+	if sl.tls {
+		err = sl.server.ServeTLS(listener, "", "")
+	} else {
+		err = sl.server.Serve(listener)
+	}
+	if err == http.ErrServerClosed {
+		return
+	}
+	fmt.Printf("Error serving port %d: %v\n", sl.port, err)
+
+	sharedListeners.Lock()
+	if sharedListeners.ports[sl.port] == sl {
+		delete(sharedListeners.ports, sl.port)
+	}
+	sharedListeners.Unlock()
+
+	sl.mu.Lock()
+	sites := sl.sites
+	sl.sites = nil
+	sl.mu.Unlock()
+	for _, st := range sites {
+		st.server.serverExited(sl.server, st.done, err)
+	}
+}
+
+// leave removes the site of a server, shutting the listener down when it was
+// the last one. Requests the site is still serving are given until ctx
+// expires to complete.
+func (sl *sharedListener) leave(ctx context.Context, s *Server) error {
+	sharedListeners.Lock()
+	sl.mu.Lock()
+	var st *site
+	for i, other := range sl.sites {
+		if other.server == s {
+			st = other
+			sl.sites = append(sl.sites[:i:i], sl.sites[i+1:]...)
+			break
+		}
+	}
+	last := len(sl.sites) == 0
+	if st != nil && last && sharedListeners.ports[sl.port] == sl {
+		delete(sharedListeners.ports, sl.port)
+	}
+	sl.mu.Unlock()
+	sharedListeners.Unlock()
+
+	// The listener already stopped and told the site so
+	if st == nil {
+		return nil
+	}
+
+	var err error
+	if last {
+		err = sl.server.Shutdown(ctx)
+	} else {
+		err = waitIdle(ctx, s.stats)
+	}
+	st.done <- nil
+	return err
+}
+
+// waitIdle waits until a server has no requests in flight or ctx expires
+func waitIdle(ctx context.Context, st *stats) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for st.inFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// lookup returns the site serving a hostname: an exact match first, then the
+// most specific wildcard, then the default site
+func (sl *sharedListener) lookup(host string) *site {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+
+	var wildcard, fallback *site
+	longest := 0
+	for _, st := range sl.sites {
+		for _, pattern := range st.hosts {
+			if !matchHost(pattern, host) {
+				continue
+			}
+			if !strings.HasPrefix(pattern, "*") {
+				return st
+			}
+			if len(pattern) > longest {
+				wildcard, longest = st, len(pattern)
+			}
+		}
+		if st.isDefault && fallback == nil {
+			fallback = st
+		}
+	}
+	if wildcard != nil {
+		return wildcard
+	}
+	return fallback
+}
+
+func (sl *sharedListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	st := sl.lookup(requestHost(r.Host))
+	if st == nil {
+		http.Error(w, "No site is configured for this host", http.StatusNotFound)
+		return
+	}
+	st.handler.ServeHTTP(w, r)
+}
+
+// certificate returns the certificate of the site a TLS client asks for,
+// falling back to the default site and then to any site
+func (sl *sharedListener) certificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if st := sl.lookup(requestHost(hello.ServerName)); st != nil {
+		return st.cert, nil
+	}
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	if len(sl.sites) == 0 {
+		return nil, fmt.Errorf("no site is configured on port %d", sl.port)
+	}
+	return sl.sites[0].cert, nil
+}
+
+// probeHost returns a hostname that selects an instance on a shared port, or
+// an empty string when any hostname will do
+func probeHost(instance config.InstanceConfig) string {
+	if instance.DefaultHost {
+		return ""
+	}
+	for _, host := range instance.Hosts {
+		if !strings.HasPrefix(host, "*") {
+			return host
+		}
+	}
+	for _, host := range instance.Hosts {
+		return "www" + strings.TrimPrefix(host, "*")
+	}
+	return ""
+}
+
+// SharedSites returns the virtual hosts on the port of an instance, itself
+// included, that are marked as running in one of the given processes. A
+// process serving a shared port hosts all of them.
+func SharedSites(instance config.InstanceConfig, pids ...int) ([]config.InstanceConfig, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %v", err)
+	}
+
+	var sites []config.InstanceConfig
+	for name, other := range cfg.Instances {
+		if other.Port != instance.Port || !other.VirtualHost() || !other.IsRunning {
+			continue
+		}
+		for _, pid := range pids {
+			if other.PID == pid {
+				other.Name = name
+				sites = append(sites, other)
+				break
+			}
+		}
+	}
+	return sites, nil
+}
+
+// sharedHostPID returns the PID of a live process already serving virtual
+// hosts on the port of an instance, if there is one
+func sharedHostPID(cfg *config.Config, instance config.InstanceConfig) (int, bool) {
+	if !instance.VirtualHost() {
+		return 0, false
+	}
+	for name, other := range cfg.Instances {
+		if name == instance.Name || other.Port != instance.Port || !other.VirtualHost() || !other.IsRunning || other.PID <= 0 {
+			continue
+		}
+		if processAlive(other.PID) {
+			return other.PID, true
+		}
+	}
+	return 0, false
+}
+
+// sharesProcess reports whether other running instances are served by the
+// process with the given PID
+func sharesProcess(cfg *config.Config, name string, pid int) bool {
+	for other, instance := range cfg.Instances {
+		if other != name && instance.IsRunning && instance.PID == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// processAlive reports whether a process with the given PID exists
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// mergeHosts appends the hosts missing from a list
+func mergeHosts(list, hosts []string) []string {
+	for _, host := range hosts {
+		found := false
+		for _, existing := range list {
+			if strings.EqualFold(existing, host) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, host)
+		}
+	}
+	return list
+}
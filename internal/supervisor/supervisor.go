@@ -0,0 +1,136 @@
+package supervisor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mguptahub/nanoHttp/internal/config"
+)
+
+const (
+	defaultBackoff    = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+// Policy decides whether and when an exited instance is restarted
+type Policy struct {
+	Mode        string
+	MaxRestarts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// NewPolicy builds a policy from an instance's restart configuration. A nil
+// configuration never restarts.
+func NewPolicy(cfg *config.RestartPolicy) Policy {
+	policy := Policy{
+		Mode:       config.RestartNever,
+		Backoff:    defaultBackoff,
+		MaxBackoff: defaultMaxBackoff,
+	}
+	if cfg == nil {
+		return policy
+	}
+
+	if cfg.Policy != "" {
+		policy.Mode = cfg.Policy
+	}
+	policy.MaxRestarts = cfg.MaxRestarts
+	if cfg.Backoff > 0 {
+		policy.Backoff = time.Duration(cfg.Backoff)
+	}
+	if cfg.MaxBackoff > 0 {
+		policy.MaxBackoff = time.Duration(cfg.MaxBackoff)
+	}
+	if policy.MaxBackoff < policy.Backoff {
+		policy.MaxBackoff = policy.Backoff
+	}
+	return policy
+}
+
+// ValidateMode checks that a restart policy name is known
+func ValidateMode(mode string) error {
+	switch mode {
+	case config.RestartNever, config.RestartOnFailure, config.RestartAlways:
+		return nil
+	}
+	return fmt.Errorf("invalid restart policy %q (expected %s, %s or %s)",
+		mode, config.RestartNever, config.RestartOnFailure, config.RestartAlways)
+}
+
+// Enabled reports whether the policy ever restarts an instance
+func (p Policy) Enabled() bool {
+	return p.Mode == config.RestartOnFailure || p.Mode == config.RestartAlways
+}
+
+// ShouldRestart reports whether a run that ended with err should be restarted,
+// given how many restarts have already happened. A MaxRestarts of zero means
+// there is no limit.
+func (p Policy) ShouldRestart(err error, restarts int) bool {
+	if p.MaxRestarts > 0 && restarts >= p.MaxRestarts {
+		return false
+	}
+
+	switch p.Mode {
+	case config.RestartAlways:
+		return true
+	case config.RestartOnFailure:
+		return err != nil
+	}
+	return false
+}
+
+// Delay returns how long to wait before the given restart (starting at 1),
+// doubling the backoff each time up to MaxBackoff
+func (p Policy) Delay(restart int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < restart && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// Run calls run, restarting it according to the policy until it exits without
+// needing a restart, the restart budget is exhausted or stop is closed. After
+// every exit that was not requested through stop, onExit is called with the
+// number of restarts so far, the exit error and whether a restart follows.
+func Run(p Policy, stop <-chan struct{}, run func() error, onExit func(restarts int, err error, restarting bool)) error {
+	restarts := 0
+	for {
+		err := run()
+
+		select {
+		case <-stop:
+			return err
+		default:
+		}
+
+		restarting := p.ShouldRestart(err, restarts)
+		if restarting {
+			restarts++
+		}
+		if onExit != nil {
+			onExit(restarts, err, restarting)
+		}
+		if !restarting {
+			return err
+		}
+
+		select {
+		case <-stop:
+			return err
+		case <-time.After(p.Delay(restarts)):
+		}
+	}
+}
+
+// ExitReason describes how a run ended for display in instance listings
+func ExitReason(err error) string {
+	if err == nil {
+		return "exited normally"
+	}
+	return err.Error()
+}